@@ -59,7 +59,7 @@ func (suite *IntegrationTestSuite) TestEncryptAndDecryptFileShort() {
 	var parsed map[string]interface{}
 	fc, _ := os.ReadFile(shortFileEnc)
 	err = json.Unmarshal(fc, &parsed)
-	timestamp, _ := time.Parse("2006-01-02T15:04:05Z07:0", parsed["lastmodified"].(string))
+	timestamp, _ := time.Parse(time.RFC3339, parsed["lastmodified"].(string))
 	suite.Nil(err, "should be nil")
 	suite.Equal(createKey["kid"].(string), parsed["kid"].(string), "should be equal")
 	suite.Equal(len(parsed["chunks"].([]interface{})), 1, "should be equal")
@@ -133,7 +133,7 @@ func (suite *IntegrationTestSuite) TestEncryptAndDecryptFileLong() {
 	var parsed map[string]interface{}
 	fc, _ := os.ReadFile(longFileEnc)
 	err = json.Unmarshal(fc, &parsed)
-	timestamp, _ := time.Parse("2006-01-02T15:04:05Z07:0", parsed["lastmodified"].(string))
+	timestamp, _ := time.Parse(time.RFC3339, parsed["lastmodified"].(string))
 	suite.Nil(err, "should be nil")
 	suite.Equal(createKey["kid"].(string), parsed["kid"].(string), "should be equal")
 	suite.Equal(len(parsed["chunks"].([]interface{})), 2, "should be equal")
@@ -7,6 +7,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	"os"
+	"os/exec"
 	"strings"
 )
 
@@ -28,6 +29,12 @@ func run(args []string) error {
 		EnvVars:  []string{"SECRET"},
 	}
 
+	flagYes := cli.BoolFlag{
+		Name:     "yes",
+		Aliases:  []string{"y"},
+		Usage:    "Dont ask for confirmation",
+		Required: false,
+	}
 	flagKey := cli.StringFlag{
 		Name:     "key",
 		Aliases:  []string{"k"},
@@ -36,10 +43,18 @@ func run(args []string) error {
 		EnvVars:  []string{"KEY"},
 	}
 
+	flagCertificate := cli.StringFlag{
+		Name:     "certificate",
+		Aliases:  []string{"c"},
+		Usage:    "Name of the certificate",
+		Required: true,
+		EnvVars:  []string{"CERTIFICATE"},
+	}
+
 	flagVersion := cli.StringFlag{
 		Name:     "version",
 		Aliases:  []string{"v"},
-		Usage:    "Key or secret version",
+		Usage:    "Key, secret or certificate version",
 		Required: false,
 		EnvVars:  []string{"VERSION"},
 	}
@@ -54,10 +69,17 @@ func run(args []string) error {
 	flagBackupFile := cli.StringFlag{
 		Name:     "file",
 		Aliases:  []string{"f"},
-		Usage:    "Backup filename - defaults to \"[KEY|SECRET].pem\"",
+		Usage:    "Backup filename - defaults to \"[KEY|SECRET|CERTIFICATE].pem\"",
 		Required: false,
 	}
 
+	flagRestoreFile := cli.StringFlag{
+		Name:     "file",
+		Aliases:  []string{"f"},
+		Usage:    "Backup file to restore",
+		Required: true,
+	}
+
 	flagEncryptFile := cli.StringFlag{
 		Name:     "file",
 		Aliases:  []string{"f"},
@@ -65,6 +87,108 @@ func run(args []string) error {
 		Required: true,
 	}
 
+	flagCompression := cli.StringFlag{
+		Name:     "compress",
+		Aliases:  []string{"c"},
+		Usage:    "Compress the file before encryption, either \"gzip\" or \"zstd\"",
+		Required: false,
+	}
+
+	flagDeterministic := cli.BoolFlag{
+		Name:     "deterministic",
+		Aliases:  []string{"d"},
+		Usage:    "Keep the existing encrypted file if the content of the file didnt change",
+		Required: false,
+	}
+
+	flagEditFile := cli.StringFlag{
+		Name:     "file",
+		Aliases:  []string{"f"},
+		Usage:    "Encrypted file to edit",
+		Required: true,
+	}
+
+	flagTemplateFile := cli.StringFlag{
+		Name:     "file",
+		Aliases:  []string{"f"},
+		Usage:    "Values template referencing keyvault secrets",
+		Required: true,
+	}
+
+	flagChartFile := cli.StringFlag{
+		Name:     "file",
+		Aliases:  []string{"f"},
+		Usage:    "Packaged chart (.tgz) to push, can be given as argument as well",
+		Required: false,
+	}
+
+	// flags of the secrets copy and sync commands
+	flagsSync := []cli.Flag{
+		&cli.StringFlag{
+			Name:     "from",
+			Usage:    "Name of the keyvault to copy the secrets from",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "to",
+			Usage:    "Name of the keyvault to copy the secrets to",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "prefix",
+			Usage: "Only copy secrets with names starting with the prefix",
+		},
+		&cli.StringSliceFlag{
+			Name:  "tag",
+			Usage: "Only copy secrets with the tag, in the form name or name=value, can be specified multiple times",
+		},
+		&cli.StringFlag{
+			Name:  "rename",
+			Usage: "Rename the secrets in the form pattern=replacement, e.g. \"^staging-(.*)=prod-$1\"",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Only print the changes without writing them",
+		},
+	}
+	syncOptions := func(c *cli.Context, prune bool) cmd.SyncSecretsOptions {
+		return cmd.SyncSecretsOptions{
+			Prefix: c.String("prefix"),
+			Tags:   c.StringSlice("tag"),
+			Rename: c.String("rename"),
+			Prune:  prune,
+			DryRun: c.Bool("dry-run"),
+			Yes:    c.Bool("yes"),
+		}
+	}
+
+	// flags of the secrets plan and apply commands
+	flagsDirectory := []cli.Flag{
+		&flagKeyVault,
+		&cli.StringFlag{
+			Name:     "directory",
+			Aliases:  []string{"d"},
+			Usage:    "Directory containing the secrets, every file is a secret named after its path",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "prefix",
+			Usage: "Prefix added to the secret names, only secrets with the prefix are compared",
+		},
+		&cli.BoolFlag{
+			Name:  "prune",
+			Usage: "Delete the secrets with the prefix which dont exist as file, requires --prefix",
+		},
+	}
+	directoryOptions := func(c *cli.Context) cmd.SecretDirectoryOptions {
+		return cmd.SecretDirectoryOptions{
+			Prefix: c.String("prefix"),
+			Prune:  c.Bool("prune"),
+			Plan:   c.String("plan"),
+			Yes:    c.Bool("yes"),
+		}
+	}
+
 	// the file decrypt option allows overwriting of the given keyvault, key and version
 	// to do this we can specify optional values for keyvault, key and versio
 	flagKeyVaultOptional := flagKeyVault
@@ -97,10 +221,80 @@ func run(args []string) error {
 					if len(u) <= 0 {
 						return errors.New("full-URL argument missing")
 					}
-					return cmd.Download(u)
+					return cmd.Download(u, cmd.TLSFiles{
+						CertFile: c.Args().Get(0),
+						KeyFile:  c.Args().Get(1),
+						CaFile:   c.Args().Get(2),
+					})
 
 				},
 			},
+			{
+				Name:  "render",
+				Usage: "Render values template, replace secret references with the secrets from keyvault and print the result to stdout",
+				Flags: []cli.Flag{
+					&flagTemplateFile,
+				},
+				Action: func(c *cli.Context) error {
+					return cmd.Render(c.String("file"))
+				},
+			},
+			{
+				Name:  "post-render",
+				Usage: "Read rendered manifests from stdin, replace keyvault+secret:// placeholders with the secrets and print the result, for usage with helm --post-renderer",
+				Action: func(c *cli.Context) error {
+					return cmd.PostRender()
+				},
+			},
+			{
+				Name:  "backup",
+				Usage: "Backup all secrets and keys of the keyvault, or the ones matching prefix and tags, into a single archive",
+				Flags: []cli.Flag{
+					&flagKeyVault,
+					&cli.StringFlag{
+						Name:     "output",
+						Aliases:  []string{"o"},
+						Usage:    "Backup archive to write, compressed according to the extension (.tar.zst, .tar.gz or .tar)",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "prefix",
+						Usage: "Only backup objects with names starting with the prefix",
+					},
+					&cli.StringSliceFlag{
+						Name:  "tag",
+						Usage: "Only backup objects with the tag, in the form name or name=value, can be specified multiple times",
+					},
+					&cli.StringSliceFlag{
+						Name:  "type",
+						Usage: "Object types to backup, \"secrets\" or \"keys\", can be specified multiple times (default: secrets and keys)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return cmd.BackupVault(c.String("keyvault"), c.String("output"), c.String("prefix"), c.StringSlice("tag"), c.StringSlice("type"))
+				},
+			},
+			{
+				Name:  "restore",
+				Usage: "Restore the secrets and keys of a backup archive into the keyvault and print the status of every object",
+				Flags: []cli.Flag{
+					&flagKeyVault,
+					&cli.StringFlag{
+						Name:     "input",
+						Aliases:  []string{"i"},
+						Usage:    "Backup archive to restore",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "overwrite",
+						Usage: "Delete and purge existing objects before restoring them instead of skipping them",
+					},
+					&flagYes,
+				},
+				Action: func(c *cli.Context) error {
+					return cmd.RestoreVault(c.String("keyvault"), c.String("input"), c.Bool("overwrite"), c.Bool("yes"))
+				},
+			},
 			{
 				Name:    "secrets",
 				Aliases: []string{"s", "secret"},
@@ -108,14 +302,18 @@ func run(args []string) error {
 				Subcommands: []*cli.Command{
 					{
 						Name:  "get",
-						Usage: "Get base64 encoded secret and decode it",
+						Usage: "Get secret, with --decode only the decoded value is printed",
 						Flags: []cli.Flag{
 							&flagKeyVault,
 							&flagSecret,
 							&flagVersion,
+							&cli.BoolFlag{
+								Name:  "decode",
+								Usage: "Print the decoded value instead of the secret as json",
+							},
 						},
 						Action: func(c *cli.Context) error {
-							return cmd.GetSecret(c.String("keyvault"), c.String("secret"), c.String("version"))
+							return cmd.GetSecret(c.String("keyvault"), c.String("secret"), c.String("version"), c.Bool("decode"))
 						},
 					},
 					{
@@ -135,9 +333,175 @@ func run(args []string) error {
 							&flagKeyVault,
 							&flagSecret,
 							&flagSecretFile,
+							&cli.BoolFlag{
+								Name:  "force",
+								Usage: "Put a new secret version even if the value is unchanged",
+							},
+							&cli.StringSliceFlag{
+								Name:  "tag",
+								Usage: "Tag of the secret in the form name=value, can be specified multiple times",
+							},
+							&cli.BoolFlag{
+								Name:  "raw",
+								Usage: "Put the file as it is instead of base64 encoding it",
+							},
+							&cli.StringFlag{
+								Name:  "content-type",
+								Usage: "Content type of the secret, only base64 values are decoded (default: base64, text/plain with --raw)",
+							},
+							&cli.StringFlag{
+								Name:  "expires",
+								Usage: "Expiry date of the secret (RFC3339 timestamp or YYYY-MM-DD)",
+							},
+							&cli.StringFlag{
+								Name:  "not-before",
+								Usage: "Date before which the secret cant be used (RFC3339 timestamp or YYYY-MM-DD)",
+							},
+							&cli.BoolFlag{
+								Name:  "disabled",
+								Usage: "Put the new secret version disabled",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.PutSecret(c.String("keyvault"), c.String("secret"), c.String("file"), cmd.PutSecretOptions{
+								Force:       c.Bool("force"),
+								Raw:         c.Bool("raw"),
+								Tags:        c.StringSlice("tag"),
+								ContentType: c.String("content-type"),
+								Expires:     c.String("expires"),
+								NotBefore:   c.String("not-before"),
+								Disabled:    c.Bool("disabled"),
+							})
+						},
+					},
+					{
+						Name:  "edit",
+						Usage: "Decode the secret, open it in $EDITOR and put a new version into keyvault if it was changed",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+							&flagSecret,
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.EditSecret(c.String("keyvault"), c.String("secret"))
+						},
+					},
+					{
+						Name:  "versions",
+						Usage: "List all versions of the secret with their attributes and tags",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+							&flagSecret,
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.SecretVersions(c.String("keyvault"), c.String("secret"))
+						},
+					},
+					{
+						Name:  "rollback",
+						Usage: "Put the value of a former version as new version of the secret, or disable a version",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+							&flagSecret,
+							&cli.StringFlag{
+								Name:  "to",
+								Usage: "Version to roll back to",
+							},
+							&cli.StringFlag{
+								Name:  "disable",
+								Usage: "Version to disable, e.g. a leaked version",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.RollbackSecret(c.String("keyvault"), c.String("secret"), c.String("to"), c.String("disable"))
+						},
+					},
+					{
+						Name:  "copy",
+						Usage: "Copy the secrets matching prefix and tags into another keyvault, only changed secrets are written",
+						Flags: flagsSync,
+						Action: func(c *cli.Context) error {
+							return cmd.SyncSecrets(c.String("from"), c.String("to"), syncOptions(c, false))
+						},
+					},
+					{
+						Name:  "sync",
+						Usage: "Copy the secrets matching prefix and tags into another keyvault and delete the ones missing in the source keyvault",
+						Flags: append(flagsSync, &flagYes),
+						Action: func(c *cli.Context) error {
+							return cmd.SyncSecrets(c.String("from"), c.String("to"), syncOptions(c, true))
 						},
+					},
+					{
+						Name:  "plan",
+						Usage: "Print the secrets to create, update and delete to bring the keyvault in line with the files of the directory as json",
+						Flags: flagsDirectory,
 						Action: func(c *cli.Context) error {
-							return cmd.PutSecret(c.String("keyvault"), c.String("secret"), c.String("file"))
+							return cmd.PlanSecrets(c.String("keyvault"), c.String("directory"), directoryOptions(c))
+						},
+					},
+					{
+						Name:  "apply",
+						Usage: "Put the new and changed files of the directory as secrets into the keyvault, with --prune delete the secrets which dont exist as file",
+						Flags: append(flagsDirectory,
+							&cli.StringFlag{
+								Name:  "plan",
+								Usage: "Reviewed plan, the apply fails if the changes differ from the plan",
+							},
+							&flagYes,
+						),
+						Action: func(c *cli.Context) error {
+							return cmd.ApplySecrets(c.String("keyvault"), c.String("directory"), directoryOptions(c))
+						},
+					},
+					{
+						Name:  "delete",
+						Usage: "Delete all versions of the secret. In keyvaults with soft-delete enabled the secret can be recovered until it is purged",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+							&flagSecret,
+							&flagYes,
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.DeleteSecret(c.String("keyvault"), c.String("secret"), c.Bool("yes"))
+						},
+					},
+					{
+						Name:  "deleted",
+						Usage: "Manage deleted secrets of keyvaults with soft-delete enabled",
+						Subcommands: []*cli.Command{
+							{
+								Name:  "list",
+								Usage: "List all deleted secrets with their scheduled purge date",
+								Flags: []cli.Flag{
+									&flagKeyVault,
+								},
+								Action: func(c *cli.Context) error {
+									return cmd.ListDeletedSecrets(c.String("keyvault"))
+								},
+							},
+						},
+					},
+					{
+						Name:  "recover",
+						Usage: "Recover a deleted secret",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+							&flagSecret,
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.RecoverSecret(c.String("keyvault"), c.String("secret"))
+						},
+					},
+					{
+						Name:  "purge",
+						Usage: "Permanently delete a deleted secret",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+							&flagSecret,
+							&flagYes,
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.PurgeSecret(c.String("keyvault"), c.String("secret"), c.Bool("yes"))
 						},
 					},
 					{
@@ -156,12 +520,23 @@ func run(args []string) error {
 							return cmd.BackupSecret(c.String("keyvault"), c.String("secret"), fn)
 						},
 					},
+					{
+						Name:  "restore",
+						Usage: "Restore azure keyvault secret from a backup file into the keyvault",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+							&flagRestoreFile,
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.RestoreSecret(c.String("keyvault"), c.String("file"))
+						},
+					},
 				},
 			},
 			{
 				Name:    "keys",
 				Aliases: []string{"k", "key"},
-				Usage:   "create, export and list keys",
+				Usage:   "create, export, list and delete keys",
 				Subcommands: []*cli.Command{
 					{
 						Name:  "create",
@@ -190,6 +565,17 @@ func run(args []string) error {
 							return cmd.BackupKey(c.String("keyvault"), c.String("key"), fn)
 						},
 					},
+					{
+						Name:  "restore",
+						Usage: "Restore azure keyvault key from a backup file into the keyvault",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+							&flagRestoreFile,
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.RestoreKey(c.String("keyvault"), c.String("file"))
+						},
+					},
 					{
 						Name:  "list",
 						Usage: "List all keys in the keyvault",
@@ -200,6 +586,149 @@ func run(args []string) error {
 							return cmd.ListKeys(c.String("keyvault"))
 						},
 					},
+					{
+						Name:  "delete",
+						Usage: "Delete all versions of the key. In keyvaults with soft-delete enabled the key can be recovered until it is purged",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+							&flagKey,
+							&flagYes,
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.DeleteKey(c.String("keyvault"), c.String("key"), c.Bool("yes"))
+						},
+					},
+					{
+						Name:  "deleted",
+						Usage: "Manage deleted keys of keyvaults with soft-delete enabled",
+						Subcommands: []*cli.Command{
+							{
+								Name:  "list",
+								Usage: "List all deleted keys with their scheduled purge date",
+								Flags: []cli.Flag{
+									&flagKeyVault,
+								},
+								Action: func(c *cli.Context) error {
+									return cmd.ListDeletedKeys(c.String("keyvault"))
+								},
+							},
+						},
+					},
+					{
+						Name:  "recover",
+						Usage: "Recover a deleted key",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+							&flagKey,
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.RecoverKey(c.String("keyvault"), c.String("key"))
+						},
+					},
+					{
+						Name:  "purge",
+						Usage: "Permanently delete a deleted key",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+							&flagKey,
+							&flagYes,
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.PurgeKey(c.String("keyvault"), c.String("key"), c.Bool("yes"))
+						},
+					},
+				},
+			},
+			{
+				Name:    "certs",
+				Aliases: []string{"c", "cert", "certificates"},
+				Usage:   "list, get, backup and restore certificates",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "List all certificates in the keyvault",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.ListCertificates(c.String("keyvault"))
+						},
+					},
+					{
+						Name:  "get",
+						Usage: "Get certificate metadata and public certificate",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+							&flagCertificate,
+							&flagVersion,
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.GetCertificate(c.String("keyvault"), c.String("certificate"), c.String("version"))
+						},
+					},
+					{
+						Name:  "backup",
+						Usage: "Backup azure keyvault certificate. The created backup can be imported into a keyvault and reused",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+							&flagCertificate,
+							&flagBackupFile,
+						},
+						Action: func(c *cli.Context) error {
+							fn := c.String("file")
+							if fn == "" {
+								fn = fmt.Sprintf("%s.pem", strings.ToUpper(c.String("certificate")))
+							}
+							return cmd.BackupCertificate(c.String("keyvault"), c.String("certificate"), fn)
+						},
+					},
+					{
+						Name:  "restore",
+						Usage: "Restore azure keyvault certificate from a backup file",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+							&flagRestoreFile,
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.RestoreCertificate(c.String("keyvault"), c.String("file"))
+						},
+					},
+				},
+			},
+			{
+				Name:    "charts",
+				Aliases: []string{"chart"},
+				Usage:   "Store packaged helm charts in the keyvault, use keyvault+secret://<keyvault> as helm repository",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "push",
+						Usage:     "Store the packaged chart as secret, the chart metadata is added as tags",
+						ArgsUsage: "<chart.tgz>",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+							&flagChartFile,
+						},
+						Action: func(c *cli.Context) error {
+							f := c.String("file")
+							if f == "" {
+								f = c.Args().First()
+							}
+							if f == "" {
+								return errors.New("Please specify the packaged chart")
+							}
+							return cmd.PushChart(c.String("keyvault"), f)
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List all charts stored in the keyvault",
+						Flags: []cli.Flag{
+							&flagKeyVault,
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.ListCharts(c.String("keyvault"))
+						},
+					},
 				},
 			},
 			{
@@ -215,9 +744,11 @@ func run(args []string) error {
 							&flagKey,
 							&flagVersion,
 							&flagEncryptFile,
+							&flagCompression,
+							&flagDeterministic,
 						},
 						Action: func(c *cli.Context) error {
-							return cmd.EncryptFile(c.String("keyvault"), c.String("key"), c.String("version"), c.String("file"))
+							return cmd.EncryptFile(c.String("keyvault"), c.String("key"), c.String("version"), c.String("file"), c.String("compress"), c.Bool("deterministic"))
 						},
 					},
 					{
@@ -233,11 +764,43 @@ func run(args []string) error {
 							return cmd.DecryptFile(c.String("keyvault"), c.String("key"), "", c.String("file"))
 						},
 					},
+					{
+						Name:  "edit",
+						Usage: "Decrypt the given file, open it in $EDITOR and re-encrypt it with the same key if it was changed",
+						Flags: []cli.Flag{
+							&flagEditFile,
+						},
+						Action: func(c *cli.Context) error {
+							return cmd.EditFile(c.String("file"))
+						},
+					},
 				},
 			},
 		},
 	}
 
+	// helm commands wrapped by the plugin, all arguments are passed on to helm
+	for _, hc := range cmd.HelmCommands {
+		hc := hc
+		app.Commands = append(app.Commands, &cli.Command{
+			Name:            hc,
+			Usage:           fmt.Sprintf("Run helm %s, encrypted files passed with -f/--values are decrypted for the duration of the command", hc),
+			SkipFlagParsing: true,
+			Action: func(c *cli.Context) error {
+				err := cmd.Helm(hc, c.Args().Slice())
+				// helm prints its own errors, keep its exit code
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					return cli.Exit("", exitErr.ExitCode())
+				}
+				if errors.Is(err, cmd.ErrInterrupted) {
+					return cli.Exit("", 130)
+				}
+				return err
+			},
+		})
+	}
+
 	err := app.Run(args)
 	if err != nil {
 		return err
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+)
+
+// ListCertificates - List all certificates in the keyvault
+func ListCertificates(kv string) error {
+
+	// initialize keyvault object
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	// initialize list
+	cl := structs.CertificateList{}
+
+	cl.Certificates, err = cl.List(keyvault)
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(cl)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
+
+// GetCertificate - Get certificate metadata and public certificate from given keyvault
+func GetCertificate(kv string, c string, v string) error {
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	cert := structs.NewCertificate(keyvault, c, v)
+	cert, err = cert.Get()
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(cert)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
+
+// BackupCertificate - Create a backup of the specified certificate
+func BackupCertificate(kv string, c string, f string) error {
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	cert := structs.NewCertificate(keyvault, c, "")
+	err = cert.Backup(f)
+	return err
+}
+
+// RestoreCertificate - Restore a certificate from the given backup file
+func RestoreCertificate(kv string, f string) error {
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	cb, err := keyvault.RestoreCertificate(f)
+	if err != nil {
+		return err
+	}
+
+	cert := structs.NewCertificate(keyvault, "", "")
+	cert.Id = structs.KeyvaultObjectId(*cb.ID)
+	ref, err := cert.Id.ParseType("certificates")
+	if err != nil {
+		return err
+	}
+	cert.Name = ref.Name
+	cert.Version = ref.Version
+
+	j, err := json.Marshal(cert)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_ListCertificates(t *testing.T) {
+	assert := assert.New(t)
+
+	// capture stdout
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	structs.NewKeyVault = newMockKeyVault
+	expectedOutput := "{\"certificates\":[{\"id\":\"https://mykeyvault.vault.azure.net/certificates/certificate-0/123456789\",\"name\":\"certificate-0\",\"keyvault\":{\"Name\":\"mykeyvault\",\"BaseUrl\":\"https://mykeyvault.vault.azure.net\"},\"version\":\"123456789\"},{\"id\":\"https://mykeyvault.vault.azure.net/certificates/certificate-1/123456789\",\"name\":\"certificate-1\",\"keyvault\":{\"Name\":\"mykeyvault\",\"BaseUrl\":\"https://mykeyvault.vault.azure.net\"},\"version\":\"123456789\"}]}"
+	err := ListCertificates("mykeyvault")
+	assert.Nil(err, "should be nil")
+
+	// read in output
+	w.Close()
+	out, _ := ioutil.ReadAll(r)
+	os.Stdout = oldStdout
+
+	assert.Equal(expectedOutput, string(out))
+}
+
+func Test_RestoreCertificate(t *testing.T) {
+	assert := assert.New(t)
+
+	// capture stdout
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	structs.NewKeyVault = newMockKeyVault
+	expectedOutput := "{\"id\":\"https://mykeyvault.vault.azure.net/certificates/restored/123456789\",\"name\":\"restored\",\"keyvault\":{\"Name\":\"mykeyvault\",\"BaseUrl\":\"https://mykeyvault.vault.azure.net\"},\"version\":\"123456789\"}"
+	err := RestoreCertificate("mykeyvault", "RESTORED.pem")
+	assert.Nil(err, "should be nil")
+
+	// read in output
+	w.Close()
+	out, _ := ioutil.ReadAll(r)
+	os.Stdout = oldStdout
+
+	assert.Equal(expectedOutput, string(out))
+}
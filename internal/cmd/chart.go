@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"os"
+)
+
+// PushChart - store the packaged chart as secret in the keyvault
+func PushChart(kv string, f string) error {
+
+	pkg, err := os.ReadFile(f)
+	if err != nil {
+		return err
+	}
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	chart, err := structs.NewChartFromPackage(keyvault, pkg)
+	if err != nil {
+		return err
+	}
+	chart, err = chart.Push(pkg)
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(chart)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
+
+// ListCharts - List all charts stored in the keyvault
+func ListCharts(kv string) error {
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	cl := structs.ChartList{}
+	cl.Charts, err = cl.List(keyvault)
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(cl)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
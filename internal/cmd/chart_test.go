@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault/keyvaulttest"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeChartPackage - write a packaged chart with the given name and version
+func writeChartPackage(t *testing.T, name string, version string) string {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	chartYaml := []byte("apiVersion: v2\nname: " + name + "\nversion: " + version + "\n")
+	_ = tw.WriteHeader(&tar.Header{Name: name + "/Chart.yaml", Mode: 0644, Size: int64(len(chartYaml))})
+	_, _ = tw.Write(chartYaml)
+	_ = tw.Close()
+	_ = gz.Close()
+
+	f := filepath.Join(t.TempDir(), name+"-"+version+".tgz")
+	_ = os.WriteFile(f, buf.Bytes(), 0644)
+	return f
+}
+
+func Test_PushChart(t *testing.T) {
+	assert := assert.New(t)
+
+	store := keyvaulttest.NewStore()
+	structs.NewKeyVault = newStoreMockKeyVault(store)
+	defer func() { structs.NewKeyVault = newMockKeyVault }()
+	pkg := writeChartPackage(t, "example", "1.2.3")
+
+	// capture stdout
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	err := PushChart("mykeyvault", pkg)
+	assert.Nil(err, "should be nil")
+	err = ListCharts("mykeyvault")
+	assert.Nil(err, "should be nil")
+
+	w.Close()
+	out, _ := ioutil.ReadAll(r)
+	os.Stdout = oldStdout
+
+	assert.Contains(string(out), "\"chart\":\"example\",\"version\":\"1.2.3\"")
+	assert.Contains(string(out), "{\"charts\":[{")
+
+	err = PushChart("mykeyvault", filepath.Join(t.TempDir(), "missing.tgz"))
+	assert.Error(err, "should be error")
+}
+
+func Test_chartUri_download(t *testing.T) {
+	assert := assert.New(t)
+
+	store := keyvaulttest.NewStore()
+	structs.NewKeyVault = newStoreMockKeyVault(store)
+	defer func() { structs.NewKeyVault = newMockKeyVault }()
+	pkg := writeChartPackage(t, "example", "1.2.3")
+	kv, _ := structs.NewKeyVault("mykeyvault")
+	content, _ := os.ReadFile(pkg)
+	chart, _ := structs.NewChartFromPackage(kv, content)
+	_, _ = chart.Push(content)
+
+	// helm retrieves the index first and downloads the chart with the url of the index entry
+	u, err := parseUri("keyvault+secret://mykeyvault/index.yaml")
+	assert.Nil(err, "should be nil")
+	assert.IsType(&chartIndexUri{}, u)
+	index, err := u.download()
+	assert.Nil(err, "should be nil")
+	chartUrl := "keyvault+secret://mykeyvault/" + chart.Name + ".tgz"
+	assert.Contains(index, "        - "+chartUrl+"\n")
+
+	u, err = parseUri(chartUrl)
+	assert.Nil(err, "should be nil")
+	assert.IsType(&chartUri{}, u)
+	downloaded, err := u.download()
+	assert.Nil(err, "should be nil")
+	assert.Equal(string(content), downloaded, "should be equal")
+
+	_, err = (&chartUri{"keyvault+secret://mykeyvault/" + strings.Replace(chart.Name, "a", "b", 1) + ".tgz"}).download()
+	assert.Error(err, "should be error")
+}
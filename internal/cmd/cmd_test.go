@@ -2,11 +2,16 @@ package cmd
 
 import (
 	"fmt"
-	mskeyvault "github.com/Azure/azure-sdk-for-go/profiles/latest/keyvault/keyvault"
+	mskeyvault "github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/date"
 	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault/keyvaulttest"
 	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"net/http"
+	"os"
+	"time"
 )
 
 func newMockKeyVault(name string) (keyvault.KeyvaultInterface, error) {
@@ -16,9 +21,20 @@ func newMockKeyVault(name string) (keyvault.KeyvaultInterface, error) {
 	return &kv, nil
 }
 
+// newStoreMockKeyVault - return a mock keyvault constructor sharing the given in memory secrets
+func newStoreMockKeyVault(store *keyvaulttest.Store) func(name string) (keyvault.KeyvaultInterface, error) {
+	return func(name string) (keyvault.KeyvaultInterface, error) {
+		kv := MockKeyVault{Store: store}
+		kv.SetKeyvaultName(name)
+		return &kv, nil
+	}
+}
+
 type MockKeyVault struct {
 	Name    string
 	BaseUrl string
+	// optional in memory secrets, without store the mock returns fixed values
+	Store *keyvaulttest.Store `json:"-"`
 }
 
 func (m *MockKeyVault) NewAuthorizer() (autorest.Authorizer, error) {
@@ -37,6 +53,9 @@ func (m *MockKeyVault) SetKeyvaultName(name string) {
 }
 
 func (m *MockKeyVault) GetSecret(name string, version string) (mskeyvault.SecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.Get(name, version)
+	}
 	id := string(structs.NewKeyvaultObjectId(m.Name, "secrets", name, version))
 	value := "Exammple Value"
 	return mskeyvault.SecretBundle{
@@ -45,16 +64,22 @@ func (m *MockKeyVault) GetSecret(name string, version string) (mskeyvault.Secret
 	}, nil
 }
 
-func (m *MockKeyVault) PutSecret(name string, value string) (mskeyvault.SecretBundle, error) {
-	version := "123456"
-	id := string(structs.NewKeyvaultObjectId(m.Name, "secrets", name, version))
+func (m *MockKeyVault) SetSecret(name string, parameters mskeyvault.SecretSetParameters) (mskeyvault.SecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.Set(m.Name, name, parameters), nil
+	}
+	id := string(structs.NewKeyvaultObjectId(m.Name, "secrets", name, "123456"))
 	return mskeyvault.SecretBundle{
-		ID:    &id,
-		Value: &value,
+		ID:          &id,
+		Value:       parameters.Value,
+		ContentType: parameters.ContentType,
 	}, nil
 }
 
 func (m *MockKeyVault) ListSecrets() ([]mskeyvault.SecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.List(), nil
+	}
 
 	var secrets []mskeyvault.SecretBundle
 
@@ -76,17 +101,24 @@ func (m *MockKeyVault) ListSecrets() ([]mskeyvault.SecretBundle, error) {
 	return secrets, nil
 }
 func (m *MockKeyVault) BackupSecret(secret string) (string, error) {
+	if m.Store != nil {
+		return m.Store.Backup(secret)
+	}
 	return secret, nil
 }
 
+// EncryptString - the mock keyvault "encrypts" by returning the given value
 func (m *MockKeyVault) EncryptString(key string, version string, encoded string) (mskeyvault.KeyOperationResult, error) {
-	//TODO implement me
-	panic("implement me")
+	return mskeyvault.KeyOperationResult{
+		Result: &encoded,
+	}, nil
 }
 
+// DecryptString - the mock keyvault "decrypts" by returning the given value
 func (m *MockKeyVault) DecryptString(key string, version string, encrypted string) (mskeyvault.KeyOperationResult, error) {
-	//TODO implement me
-	panic("implement me")
+	return mskeyvault.KeyOperationResult{
+		Result: &encrypted,
+	}, nil
 }
 
 func (m *MockKeyVault) ListKeys() ([]mskeyvault.KeyBundle, error) {
@@ -95,8 +127,7 @@ func (m *MockKeyVault) ListKeys() ([]mskeyvault.KeyBundle, error) {
 }
 
 func (m *MockKeyVault) BackupKey(key string) (string, error) {
-	//TODO implement me
-	panic("implement me")
+	return key, nil
 }
 
 func (m *MockKeyVault) CreateKey(key string) (mskeyvault.KeyBundle, error) {
@@ -107,3 +138,130 @@ func (m *MockKeyVault) GetKey(key string, version string) (mskeyvault.KeyBundle,
 	//TODO implement me
 	panic("implement me")
 }
+
+func (m *MockKeyVault) GetCertificate(name string, version string) (mskeyvault.CertificateBundle, error) {
+	id := string(structs.NewKeyvaultObjectId(m.Name, "certificates", name, version))
+	return mskeyvault.CertificateBundle{
+		ID: &id,
+	}, nil
+}
+
+func (m *MockKeyVault) ListCertificates() ([]mskeyvault.CertificateBundle, error) {
+	var certificates []mskeyvault.CertificateBundle
+
+	for i := 0; i < 2; i++ {
+		id := string(structs.NewKeyvaultObjectId(m.Name, "certificates", fmt.Sprintf("certificate-%v", i), "123456789"))
+		certificates = append(certificates, mskeyvault.CertificateBundle{
+			ID: &id,
+		})
+	}
+
+	return certificates, nil
+}
+
+func (m *MockKeyVault) BackupCertificate(name string) (string, error) {
+	return name, nil
+}
+
+func (m *MockKeyVault) RestoreCertificate(file string) (mskeyvault.CertificateBundle, error) {
+	return m.GetCertificate("restored", "123456789")
+}
+
+func (m *MockKeyVault) ListSecretVersions(name string) ([]mskeyvault.SecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.Versions(name)
+	}
+	sb, err := m.GetSecret(name, "123456")
+	sb.Value = nil
+	return []mskeyvault.SecretBundle{sb}, err
+}
+
+func (m *MockKeyVault) UpdateSecret(name string, version string, parameters mskeyvault.SecretUpdateParameters) (mskeyvault.SecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.Update(name, version, parameters)
+	}
+	return m.GetSecret(name, version)
+}
+
+func (m *MockKeyVault) DeleteSecret(name string) (mskeyvault.DeletedSecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.Delete(name)
+	}
+	sb, err := m.GetSecret(name, "123456")
+	return mskeyvault.DeletedSecretBundle{ID: sb.ID}, err
+}
+
+func (m *MockKeyVault) ListDeletedSecrets() ([]mskeyvault.DeletedSecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.ListDeleted(), nil
+	}
+	return nil, nil
+}
+
+func (m *MockKeyVault) RecoverDeletedSecret(name string) (mskeyvault.SecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.Recover(name)
+	}
+	return m.GetSecret(name, "123456")
+}
+
+func (m *MockKeyVault) PurgeDeletedSecret(name string) error {
+	if m.Store != nil {
+		return m.Store.Purge(name)
+	}
+	return nil
+}
+
+func (m *MockKeyVault) DeleteKey(key string) (mskeyvault.DeletedKeyBundle, error) {
+	id := fmt.Sprintf("https://%s.%s/keys/%s/%s", m.Name, azure.PublicCloud.KeyVaultDNSSuffix, key, "123456789")
+	recoveryId := fmt.Sprintf("https://%s.%s/deletedkeys/%s", m.Name, azure.PublicCloud.KeyVaultDNSSuffix, key)
+	purge := date.UnixTime(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	return mskeyvault.DeletedKeyBundle{
+		RecoveryID:         &recoveryId,
+		ScheduledPurgeDate: &purge,
+		Key:                &mskeyvault.JSONWebKey{Kid: &id},
+	}, nil
+}
+
+func (m *MockKeyVault) ListDeletedKeys() ([]mskeyvault.DeletedKeyBundle, error) {
+	dk, err := m.DeleteKey("deleted-key")
+	return []mskeyvault.DeletedKeyBundle{dk}, err
+}
+
+func (m *MockKeyVault) RecoverDeletedKey(key string) (mskeyvault.KeyBundle, error) {
+	id := fmt.Sprintf("https://%s.%s/keys/%s/%s", m.Name, azure.PublicCloud.KeyVaultDNSSuffix, key, "123456789")
+	return mskeyvault.KeyBundle{Key: &mskeyvault.JSONWebKey{Kid: &id}}, nil
+}
+
+func (m *MockKeyVault) PurgeDeletedKey(key string) error {
+	return nil
+}
+
+// RestoreSecret - the backup files of the mock keyvault contain the name of the secret,
+// with store the backup contains all versions of the secret
+func (m *MockKeyVault) RestoreSecret(file string) (mskeyvault.SecretBundle, error) {
+	backup, err := os.ReadFile(file)
+	if err != nil {
+		return mskeyvault.SecretBundle{}, err
+	}
+	if m.Store != nil {
+		return m.Store.Restore(m.Name, backup)
+	}
+	return m.GetSecret(string(backup), "123456")
+}
+
+// RestoreKey - the backup files of the mock keyvault contain the name of the key,
+// the keys listed by the mock keyvault already exist
+func (m *MockKeyVault) RestoreKey(file string) (mskeyvault.KeyBundle, error) {
+	backup, err := os.ReadFile(file)
+	if err != nil {
+		return mskeyvault.KeyBundle{}, err
+	}
+	for i := 0; i < 5; i++ {
+		if string(backup) == fmt.Sprintf("key-%v", i) {
+			return mskeyvault.KeyBundle{}, autorest.DetailedError{StatusCode: http.StatusConflict, Message: "Conflict: key already exists"}
+		}
+	}
+	id := fmt.Sprintf("https://%s.%s/keys/%s/%s", m.Name, azure.PublicCloud.KeyVaultDNSSuffix, string(backup), "123456789")
+	return mskeyvault.KeyBundle{Key: &mskeyvault.JSONWebKey{Kid: &id}}, nil
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"strings"
+)
+
+// confirmInput - input of the confirmation prompts, replaced for testing
+var confirmInput io.Reader = os.Stdin
+
+// confirm - ask the user to confirm the given action, yes skips the prompt.
+// the prompt is written to stderr to keep the json output on stdout parsable
+func confirm(prompt string, yes bool) error {
+	if yes {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", prompt)
+
+	answer, err := bufio.NewReader(confirmInput).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("Aborted")
+}
+
+// printDeleted - report whether the deleted object can be recovered and print it
+func printDeleted(objectType string, d structs.DeletedObject) error {
+	if d.Recoverable() && d.ScheduledPurge != nil {
+		log.Infof("%s %s deleted, it can be recovered until %s", objectType, d.Name, d.ScheduledPurge)
+	} else if d.Recoverable() {
+		log.Infof("%s %s deleted, it can be recovered until it is purged", objectType, d.Name)
+	} else {
+		log.Warnf("%s %s deleted permanently, the keyvault doesnt have soft-delete enabled", objectType, d.Name)
+	}
+
+	j, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
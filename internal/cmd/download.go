@@ -3,11 +3,25 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
 	"net/url"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 )
 
+// environment variable with the chart directory, used to resolve file uris relative to the chart
+const chartDirEnv = "HELM_KEYVAULT_CHART_DIR"
+
+// stdoutIsTerminal - returns true if stdout is an interactive terminal, binary
+// output like pfx archives isnt written to terminals
+var stdoutIsTerminal = func() bool {
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
 // interface for different uri download functions
 type generalUri interface {
 	download() (string, error)
@@ -20,21 +34,22 @@ type keyvaultUri struct {
 
 func (u *keyvaultUri) download() (string, error) {
 
-	// replace the scheme - keyvault+secret(s):// with https
-	uri := strings.Replace(u.uri, "keyvault+secrets://", "https://", 1)
-	uri = strings.Replace(uri, "keyvault+secret://", "https://", 1)
-
-	// create secrets struct
-	secret := structs.Secret{Id: structs.KeyvaultObjectId(uri)}
-	kv, err := structs.NewKeyVault(secret.Id.GetKeyvault())
+	ref, query, err := parseObjectUri(u.uri, "secrets")
 	if err != nil {
 		return "", err
 	}
+	for q := range query {
+		if q != "path" {
+			return "", fmt.Errorf("Unsupported query parameter '%s' in download uri '%s'", q, u.uri)
+		}
+	}
 
-	secret.KeyVault = kv
-	secret.Name = secret.Id.GetName()
-	secret.Version = secret.Id.GetVersion()
+	kv, err := structs.NewKeyVault(ref.Keyvault)
+	if err != nil {
+		return "", err
+	}
 
+	secret := structs.NewSecret(kv, ref.Name, ref.Version)
 	secret, err = secret.Get()
 	if err != nil {
 		return "", err
@@ -45,53 +60,235 @@ func (u *keyvaultUri) download() (string, error) {
 		return "", err
 	}
 
+	// return the raw value wrapped into a values document at the given path
+	if path := query.Get("path"); path != "" {
+		values := structs.Values{}
+		err = values.Set(path, val)
+		if err != nil {
+			return "", err
+		}
+		return values.Yaml()
+	}
+
 	return val, nil
 }
 
-// fileUri - represents an keyvault+file uri
-type fileUri struct {
+// secretsUri - represents an keyvault+secrets uri without secret name, all secrets
+// matching the given prefix and tags are merged into a single values document
+type secretsUri struct {
 	uri string
 }
 
-func (u *fileUri) download() (string, error) {
-	// parse uri to get file path
-	parsed, err := url.Parse(u.uri)
+func (u *secretsUri) download() (string, error) {
+
+	parsed, name, err := parseKeyvaultUri(u.uri)
+	if err != nil {
+		return "", err
+	}
+	query := parsed.Query()
+	for q := range query {
+		switch q {
+		case "prefix", "tag", "separator", "root":
+		default:
+			return "", fmt.Errorf("Unsupported query parameter '%s' in download uri '%s'", q, u.uri)
+		}
+	}
+
+	tags, err := structs.ParseTags(query["tag"], true)
+	if err != nil {
+		return "", err
+	}
+	filter := structs.SecretFilter{
+		Prefix: query.Get("prefix"),
+		Tags:   tags,
+	}
+	// an empty separator keeps the secret names as flat keys
+	separator := "-"
+	if _, exists := query["separator"]; exists {
+		separator = query.Get("separator")
+	}
+
+	kv, err := structs.NewKeyVault(name)
+	if err != nil {
+		return "", err
+	}
+
+	sl := structs.SecretList{}
+	sl.Secrets, err = sl.Select(kv, filter)
+	if err != nil {
+		return "", err
+	}
+	sl.Secrets, err = sl.GetAll(sl.Secrets)
+	if err != nil {
+		return "", err
+	}
+
+	values := structs.Values{}
+	for _, s := range sl.Secrets {
+		val, err := s.Decode()
+		if err != nil {
+			return "", err
+		}
+		path, err := secretValuesPath(s.Name, filter.Prefix, separator, query.Get("root"))
+		if err != nil {
+			return "", err
+		}
+		err = values.Set(path, val)
+		if err != nil {
+			return "", fmt.Errorf("Unable to add secret %s: %v", s.Name, err)
+		}
+	}
+
+	return values.Yaml()
+}
+
+// secretValuesPath - map a secret name to a dotted values path, e.g. myapp-db-password with
+// prefix myapp- and separator - results in db.password
+func secretValuesPath(name string, prefix string, separator string, root string) (string, error) {
+	n := strings.TrimPrefix(name, prefix)
+	if n == "" {
+		return "", fmt.Errorf("Secret name %s is equal to the prefix %s", name, prefix)
+	}
+
+	keys := []string{n}
+	if separator != "" {
+		keys = strings.Split(n, separator)
+	}
+	if root != "" {
+		keys = append([]string{root}, keys...)
+	}
+	return strings.Join(keys, "."), nil
+}
+
+// chartIndexUri - represents the index.yaml of the helm repository stored in a keyvault,
+// e.g. keyvault+secret://mykeyvault/index.yaml
+type chartIndexUri struct {
+	uri string
+}
+
+func (u *chartIndexUri) download() (string, error) {
+	parsed, name, err := parseKeyvaultUri(u.uri)
 	if err != nil {
 		return "", err
 	}
 
-	var encfile structs.EncryptedFile
-	encfile, err = encfile.LoadEncryptedFile(fmt.Sprintf("%s%s", parsed.Host, parsed.Path))
+	kv, err := structs.NewKeyVault(name)
 	if err != nil {
 		return "", err
 	}
 
-	keyvault, err := structs.NewKeyVault(encfile.Kid.GetKeyvault())
+	cl := structs.ChartList{}
+	cl.Charts, err = cl.List(kv)
 	if err != nil {
 		return "", err
 	}
+	return cl.Index(fmt.Sprintf("keyvault+secret://%s", parsed.Host))
+}
+
+// chartUri - represents a packaged chart referenced by the repository index,
+// e.g. keyvault+secret://mykeyvault/helmchart-<hash>.tgz
+type chartUri struct {
+	uri string
+}
 
-	// decrypt the given data
-	encfile.EncodedData, err = encfile.DecryptData(keyvault, encfile.Kid.GetName(), encfile.Kid.GetVersion())
+func (u *chartUri) download() (string, error) {
+	parsed, name, err := parseKeyvaultUri(u.uri)
 	if err != nil {
 		return "", err
 	}
 
-	value, err := encfile.GetDecodedString()
+	kv, err := structs.NewKeyVault(name)
 	if err != nil {
 		return "", err
 	}
 
+	chart := structs.Chart{
+		Name:     strings.TrimSuffix(path.Base(parsed.Path), ".tgz"),
+		KeyVault: kv,
+	}
+	pkg, err := chart.Package()
+	if err != nil {
+		return "", err
+	}
+	return string(pkg), nil
+}
+
+// certUri - represents an keyvault+cert uri
+type certUri struct {
+	uri string
+}
+
+func (u *certUri) download() (string, error) {
+
+	ref, query, err := parseObjectUri(u.uri, "certificates")
+	if err != nil {
+		return "", err
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = structs.CertificateFormatPem
+	}
+	if format == structs.CertificateFormatPfx && stdoutIsTerminal() {
+		return "", errors.New("Refusing to write the binary pfx archive to a terminal, redirect the output into a file")
+	}
+
+	kv, err := structs.NewKeyVault(ref.Keyvault)
+	if err != nil {
+		return "", err
+	}
+
+	cert := structs.NewCertificate(kv, ref.Name, ref.Version)
+	return cert.Export(format)
+}
+
+// fileUri - represents an keyvault+file uri
+type fileUri struct {
+	uri string
+}
+
+func (u *fileUri) download() (string, error) {
+	f, err := parseFileUri(u.uri)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := decryptFileContent(f)
+	if err != nil {
+		return "", fmt.Errorf("Unable to decrypt %s: %v", f, err)
+	}
+	return value, nil
+}
+
+// tplUri - represents an keyvault+tpl uri, a values template referencing keyvault secrets
+type tplUri struct {
+	uri string
+}
+
+func (u *tplUri) download() (string, error) {
+	f, err := parseFileUri(u.uri)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := renderValuesFile(f)
+	if err != nil {
+		return "", fmt.Errorf("Unable to render %s: %v", f, err)
+	}
 	return value, nil
 }
 
 // DownloadSecret - Download and decode secret to be used as downloader plugin
-func Download(uri string) error {
+func Download(uri string, tlsFiles TLSFiles) error {
 
 	u, err := parseUri(uri)
 	if err != nil {
 		return err
 	}
+	// remote files are downloaded with the tls settings passed by helm
+	if r, ok := u.(*remoteFileUri); ok {
+		r.tls = tlsFiles
+	}
 
 	result, err := u.download()
 	if err != nil {
@@ -103,17 +300,148 @@ func Download(uri string) error {
 
 func parseUri(uri string) (generalUri, error) {
 
+	// file paths arent valid urls in all cases, they are parsed by the file uri types
+	scheme := strings.SplitN(strings.TrimPrefix(uri, "keyvault+"), "://", 2)[0]
+	if (scheme == "file+https") || (scheme == "file+oci") {
+		return &remoteFileUri{uri: uri}, nil
+	}
+	if (scheme == "file") || (scheme == "files") {
+		return &fileUri{uri}, nil
+	}
+	if scheme == "tpl" {
+		return &tplUri{uri}, nil
+	}
+
 	u, err := url.Parse(uri[len("keyvault+"):])
 	if err != nil {
 		return nil, err
 	}
 
 	if (u.Scheme == "secret") || (u.Scheme == "secrets") {
+		// without a secret name all matching secrets are downloaded
+		if strings.Trim(u.Path, "/") == "" {
+			return &secretsUri{uri}, nil
+		}
+		// helm repository index and charts
+		if strings.Trim(u.Path, "/") == "index.yaml" {
+			return &chartIndexUri{uri}, nil
+		}
+		if strings.HasSuffix(u.Path, ".tgz") {
+			return &chartUri{uri}, nil
+		}
 		return &keyvaultUri{uri}, nil
 	}
-	if (u.Scheme == "file") || (u.Scheme == "files") {
-		return &fileUri{uri}, nil
+	if u.Scheme == "cert" {
+		return &certUri{uri}, nil
 	}
 
 	return nil, errors.New("Unknown download uri received")
 }
+
+// parseKeyvaultUri - parse an uri referencing a keyvault without object and return the validated keyvault name,
+// the host is either the keyvault name or its fqdn
+func parseKeyvaultUri(uri string) (*url.URL, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, "", err
+	}
+
+	host := u.Host
+	if !strings.Contains(host, ".") {
+		host = fmt.Sprintf("%s.%s", host, azure.PublicCloud.KeyVaultDNSSuffix)
+	}
+	name, err := structs.ParseKeyvaultHost(host)
+	if err != nil {
+		return nil, "", fmt.Errorf("Invalid download uri '%s': %v", uri, err)
+	}
+	if u.User != nil || u.Port() != "" {
+		return nil, "", fmt.Errorf("Invalid download uri '%s': unexpected user or port", uri)
+	}
+	return u, name, nil
+}
+
+// parseObjectUri - parse a downloader uri into a keyvault object reference and its query parameters.
+// the uri host is either the keyvault name or its fqdn, the uri path either <name>[/<version>]
+// or <type>/<name>[/<version>]
+func parseObjectUri(uri string, ty string) (structs.ObjectRef, url.Values, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return structs.ObjectRef{}, nil, err
+	}
+
+	host := u.Host
+	if !strings.Contains(host, ".") {
+		host = fmt.Sprintf("%s.%s", host, azure.PublicCloud.KeyVaultDNSSuffix)
+	}
+	p := strings.TrimPrefix(u.Path, "/")
+	if !strings.HasPrefix(p, fmt.Sprintf("%s/", ty)) {
+		p = fmt.Sprintf("%s/%s", ty, p)
+	}
+
+	ref, err := structs.ParseKeyvaultObjectId(fmt.Sprintf("https://%s/%s", host, p))
+	if err != nil {
+		return structs.ObjectRef{}, nil, fmt.Errorf("Invalid download uri '%s': %v", uri, err)
+	}
+	return ref, u.Query(), nil
+}
+
+// parseFileUri - return the local path of a file uri. The uri isnt parsed as url as relative paths
+// like keyvault+file://../values.yaml.enc or windows paths cant be represented as url host.
+//   - keyvault+file:///abs/path, keyvault+file:///C:/path: absolute path
+//   - keyvault+file://rel/path, keyvault+file://./rel/path, keyvault+file://../rel/path: relative to the working directory
+//   - keyvault+file://rel/path?base=chart: relative to the chart directory ($HELM_KEYVAULT_CHART_DIR),
+//     set by the helm commands of the plugin for local charts
+//
+// the path is percent decoded, e.g. %20 for spaces or %3F for question marks
+func parseFileUri(uri string) (string, error) {
+	i := strings.Index(uri, "://")
+	if i < 0 {
+		return "", fmt.Errorf("Invalid file uri '%s'", uri)
+	}
+	p := uri[i+len("://"):]
+
+	base := ""
+	if q := strings.Index(p, "?"); q >= 0 {
+		query, err := url.ParseQuery(p[q+1:])
+		if err != nil {
+			return "", fmt.Errorf("Invalid file uri '%s': %v", uri, err)
+		}
+		for k := range query {
+			if k != "base" {
+				return "", fmt.Errorf("Unsupported query parameter '%s' in file uri '%s'", k, uri)
+			}
+		}
+		base = query.Get("base")
+		p = p[:q]
+	}
+
+	p, err := url.PathUnescape(p)
+	if err != nil {
+		return "", fmt.Errorf("Invalid file uri '%s': %v", uri, err)
+	}
+	if p == "" {
+		return "", fmt.Errorf("Invalid file uri '%s': missing path", uri)
+	}
+	// windows drive letters are prefixed with a slash in absolute file uris (file:///C:/path)
+	if len(p) > 3 && p[0] == '/' && p[2] == ':' && (p[3] == '/' || p[3] == '\\') {
+		p = p[1:]
+	}
+	p = filepath.FromSlash(p)
+
+	switch base {
+	case "", "cwd":
+	case "chart":
+		if filepath.IsAbs(p) {
+			return "", fmt.Errorf("Invalid file uri '%s': absolute path relative to the chart", uri)
+		}
+		dir := os.Getenv(chartDirEnv)
+		if dir == "" {
+			return "", fmt.Errorf("File uri '%s' is relative to the chart, but $%s isnt set. Set it or run the helm command through the plugin, e.g. helm keyvault template", uri, chartDirEnv)
+		}
+		p = filepath.Join(dir, p)
+	default:
+		return "", fmt.Errorf("Unsupported base '%s' in file uri '%s', use either cwd or chart", base, uri)
+	}
+
+	return filepath.Clean(p), nil
+}
@@ -1,7 +1,13 @@
 package cmd
 
 import (
+	"fmt"
+	mskeyvault "github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -22,3 +28,307 @@ func Test_parseUri_Keyvault(t *testing.T) {
 	assert.IsType(&keyvaultUri{}, parsed)
 
 }
+
+func Test_fileUri_download_InvalidKid(t *testing.T) {
+	assert := assert.New(t)
+
+	// a malformed kid results in an error instead of a panic
+	f := filepath.Join(t.TempDir(), "values.yaml.enc")
+	_ = os.WriteFile(f, []byte(`{"kid": "https://mykeyvault.vault.azure.net/keys", "chunks": ["chunk"]}`), 0644)
+
+	u := fileUri{fmt.Sprintf("keyvault+file://%s", f)}
+	_, err := u.download()
+	assert.Error(err, "should be error")
+}
+
+func Test_keyvaultUri_download_InvalidUri(t *testing.T) {
+	assert := assert.New(t)
+
+	u := keyvaultUri{"keyvault+secret://mykeyvault.vault.azure.net/"}
+	_, err := u.download()
+	assert.Error(err, "should be error")
+}
+
+func Test_parseUri_Cert(t *testing.T) {
+	assert := assert.New(t)
+
+	parsed, err := parseUri("keyvault+cert://mykeyvault/mycert?format=crt")
+	assert.Nil(err, "should be nil")
+	assert.IsType(&certUri{}, parsed)
+}
+
+func Test_parseObjectUri(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]string{
+		"keyvault+cert://mykeyvault/mycert":                                 "https://mykeyvault.vault.azure.net/certificates/mycert/",
+		"keyvault+cert://mykeyvault/mycert/v1?format=pfx":                   "https://mykeyvault.vault.azure.net/certificates/mycert/v1",
+		"keyvault+cert://mykeyvault.vault.azure.net/certificates/mycert/v1": "https://mykeyvault.vault.azure.net/certificates/mycert/v1",
+	}
+	for uri, expected := range tests {
+		ref, _, err := parseObjectUri(uri, "certificates")
+		assert.Nil(err, "should be nil")
+		assert.Equal(expected, ref.String(), "should be equal")
+	}
+
+	_, query, _ := parseObjectUri("keyvault+cert://mykeyvault/mycert?format=pfx", "certificates")
+	assert.Equal("pfx", query.Get("format"), "should be equal")
+
+	_, _, err := parseObjectUri("keyvault+cert://mykeyvault/", "certificates")
+	assert.Error(err, "should be error")
+	_, _, err = parseObjectUri("keyvault+cert://mykeyvault/mycert/v1/extra", "certificates")
+	assert.Error(err, "should be error")
+}
+
+func Test_keyvaultUri_download_Path(t *testing.T) {
+	assert := assert.New(t)
+
+	// the edit mock returns "key: value\n" as base64 encoded value
+	mock := &editMockKeyVault{versions: []string{"v1"}}
+	structs.NewKeyVault = func(name string) (keyvault.KeyvaultInterface, error) {
+		mock.SetKeyvaultName(name)
+		return mock, nil
+	}
+
+	u := keyvaultUri{"keyvault+secret://mykeyvault/mysecret?path=db.config"}
+	val, err := u.download()
+	assert.Nil(err, "should be nil")
+	assert.Equal("db:\n  config: |\n    key: value\n", val)
+
+	// without path the secret is returned verbatim, fqdn uris are still supported
+	u = keyvaultUri{"keyvault+secret://mykeyvault.vault.azure.net/secrets/mysecret"}
+	val, err = u.download()
+	assert.Nil(err, "should be nil")
+	assert.Equal("key: value\n", val)
+
+	u = keyvaultUri{"keyvault+secret://mykeyvault/mysecret?pth=db.config"}
+	_, err = u.download()
+	assert.Error(err, "should be error")
+}
+
+// valuesMockKeyVault - mock keyvault returning a fixed set of tagged secrets
+type valuesMockKeyVault struct {
+	MockKeyVault
+	secrets map[string]string
+	tags    map[string]string
+}
+
+func (m *valuesMockKeyVault) ListSecrets() ([]mskeyvault.SecretBundle, error) {
+	var secrets []mskeyvault.SecretBundle
+	for name := range m.secrets {
+		id := string(structs.NewKeyvaultObjectId(m.Name, "secrets", name, ""))
+		env := m.tags[name]
+		secrets = append(secrets, mskeyvault.SecretBundle{
+			ID:   &id,
+			Tags: map[string]*string{"env": &env},
+		})
+	}
+	return secrets, nil
+}
+
+func (m *valuesMockKeyVault) GetSecret(name string, version string) (mskeyvault.SecretBundle, error) {
+	id := string(structs.NewKeyvaultObjectId(m.Name, "secrets", name, "123456"))
+	value := m.secrets[name]
+	ct := structs.ContentTypeBase64
+	return mskeyvault.SecretBundle{
+		ID:          &id,
+		Value:       &value,
+		ContentType: &ct,
+	}, nil
+}
+
+func newValuesMockKeyVault(name string) (keyvault.KeyvaultInterface, error) {
+	kv := valuesMockKeyVault{
+		secrets: map[string]string{
+			"myapp-db-password": "cGFzc3dvcmQ=",
+			"myapp-db-user":     "dXNlcg==",
+			"myapp-token":       "dG9rZW4=",
+			"myapp-dev-token":   "ZGV2",
+			"other-token":       "b3RoZXI=",
+			"myapp-db":          "ZGI=",
+		},
+		tags: map[string]string{
+			"myapp-db-password": "prod",
+			"myapp-db-user":     "prod",
+			"myapp-token":       "prod",
+			"myapp-dev-token":   "dev",
+			"other-token":       "prod",
+			"myapp-db":          "test",
+		},
+	}
+	kv.SetKeyvaultName(name)
+	return &kv, nil
+}
+
+func Test_parseUri_Secrets(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, uri := range []string{"keyvault+secrets://mykeyvault", "keyvault+secrets://mykeyvault/?prefix=myapp-&tag=env:prod"} {
+		parsed, err := parseUri(uri)
+		assert.Nil(err, "should be nil")
+		assert.IsType(&secretsUri{}, parsed)
+	}
+}
+
+func Test_secretsUri_download(t *testing.T) {
+	assert := assert.New(t)
+
+	structs.NewKeyVault = newValuesMockKeyVault
+	defer func() { structs.NewKeyVault = newMockKeyVault }()
+
+	tests := map[string]string{
+		"keyvault+secrets://mykeyvault/?prefix=myapp-&tag=env:prod":              "db:\n  password: password\n  user: user\ntoken: token\n",
+		"keyvault+secrets://mykeyvault/?prefix=myapp-&tag=env=prod&root=secrets": "secrets:\n  db:\n    password: password\n    user: user\n  token: token\n",
+		"keyvault+secrets://mykeyvault/?prefix=myapp-&tag=env:prod&separator=":   "db-password: password\ndb-user: user\ntoken: token\n",
+		"keyvault+secrets://mykeyvault.vault.azure.net/?tag=env:dev&separator=":  "myapp-dev-token: dev\n",
+		"keyvault+secrets://mykeyvault/?prefix=none-":                            "{}\n",
+	}
+	for uri, expected := range tests {
+		parsed, err := parseUri(uri)
+		assert.Nil(err, "should be nil")
+		// the output is stable regardless of the order secrets are listed and retrieved
+		for i := 0; i < 3; i++ {
+			values, err := parsed.download()
+			assert.Nil(err, "should be nil")
+			assert.Equal(expected, values, uri)
+		}
+	}
+
+	// myapp-db conflicts with myapp-db-password and myapp-db-user
+	_, err := (&secretsUri{"keyvault+secrets://mykeyvault/?prefix=myapp-&tag=env"}).download()
+	assert.Error(err, "should be error")
+	_, err = (&secretsUri{"keyvault+secrets://mykeyvault/?unknown=value"}).download()
+	assert.Error(err, "should be error")
+}
+
+func Test_parseKeyvaultUri(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, uri := range []string{"keyvault+secrets://mykeyvault/", "keyvault+secret://MyKeyvault.vault.azure.net/index.yaml"} {
+		_, name, err := parseKeyvaultUri(uri)
+		assert.Nil(err, "should be nil")
+		assert.Equal("mykeyvault", name, uri)
+	}
+
+	// the keyvault name and host are validated for secrets, index and chart uris
+	for _, uri := range []string{
+		"keyvault+secrets://mykeyvault.evil.example.com/",
+		"keyvault+secrets://my_keyvault/",
+		"keyvault+secret://user@mykeyvault/index.yaml",
+		"keyvault+secret://mykeyvault:8443/helmchart-123.tgz",
+	} {
+		_, _, err := parseKeyvaultUri(uri)
+		assert.Error(err, uri)
+	}
+	_, err := (&secretsUri{"keyvault+secrets://mykeyvault.evil.example.com/"}).download()
+	assert.Error(err, "should be error")
+	_, err = (&chartIndexUri{"keyvault+secret://mykeyvault.evil.example.com/index.yaml"}).download()
+	assert.Error(err, "should be error")
+	_, err = (&chartUri{"keyvault+secret://mykeyvault.evil.example.com/helmchart-123.tgz"}).download()
+	assert.Error(err, "should be error")
+}
+
+func Test_secretValuesPath(t *testing.T) {
+	assert := assert.New(t)
+
+	path, err := secretValuesPath("myapp-db-password", "myapp-", "-", "")
+	assert.Nil(err, "should be nil")
+	assert.Equal("db.password", path)
+
+	path, err = secretValuesPath("myapp-db-password", "", "", "secrets")
+	assert.Nil(err, "should be nil")
+	assert.Equal("secrets.myapp-db-password", path)
+
+	_, err = secretValuesPath("myapp-", "myapp-", "-", "")
+	assert.Error(err, "should be error")
+}
+
+func Test_parseFileUri(t *testing.T) {
+	assert := assert.New(t)
+
+	t.Setenv(chartDirEnv, "/charts/example")
+
+	tests := map[string]string{
+		"keyvault+file:///abs/path/values.yaml.enc":            "/abs/path/values.yaml.enc",
+		"keyvault+files:///abs/path/values.yaml.enc":           "/abs/path/values.yaml.enc",
+		"keyvault+file://values.yaml.enc":                      "values.yaml.enc",
+		"keyvault+file://rel/path/values.yaml.enc":             "rel/path/values.yaml.enc",
+		"keyvault+file://./values.yaml.enc":                    "values.yaml.enc",
+		"keyvault+file://../values.yaml.enc":                   "../values.yaml.enc",
+		"keyvault+file://../../secrets/values.yaml.enc":        "../../secrets/values.yaml.enc",
+		"keyvault+file:///abs/my%20dir/values%3F.yaml.enc":     "/abs/my dir/values?.yaml.enc",
+		"keyvault+file://my dir/values.yaml.enc":               "my dir/values.yaml.enc",
+		"keyvault+file:///C:/secrets/values.yaml.enc":          "C:/secrets/values.yaml.enc",
+		"keyvault+file://C:/secrets/values.yaml.enc":           "C:/secrets/values.yaml.enc",
+		"keyvault+file://values.yaml.enc?base=cwd":             "values.yaml.enc",
+		"keyvault+file://secrets/values.yaml.enc?base=chart":   "/charts/example/secrets/values.yaml.enc",
+		"keyvault+file://../shared/values.yaml.enc?base=chart": "/charts/shared/values.yaml.enc",
+		"keyvault+tpl://values.tpl.yaml":                       "values.tpl.yaml",
+		"keyvault+tpl:///abs/values.tpl.yaml":                  "/abs/values.tpl.yaml",
+	}
+	for uri, expected := range tests {
+		f, err := parseFileUri(uri)
+		assert.Nil(err, uri)
+		assert.Equal(filepath.FromSlash(expected), f, uri)
+	}
+
+	for _, uri := range []string{
+		"keyvault+file://",
+		"keyvault+file:/values.yaml.enc",
+		"keyvault+file://values%zz.yaml.enc",
+		"keyvault+file://values.yaml.enc?base=other",
+		"keyvault+file://values.yaml.enc?other=value",
+		"keyvault+file:///abs/values.yaml.enc?base=chart",
+	} {
+		_, err := parseFileUri(uri)
+		assert.Error(err, uri)
+	}
+
+	t.Setenv(chartDirEnv, "")
+	_, err := parseFileUri("keyvault+file://values.yaml.enc?base=chart")
+	assert.Error(err, "should be error")
+}
+
+func Test_fileUri_download(t *testing.T) {
+	assert := assert.New(t)
+
+	structs.NewKeyVault = newMockKeyVault
+	enc := writeMockEncryptedFile(t, "key: value\n")
+	dir := filepath.Dir(enc)
+	_ = os.Mkdir(filepath.Join(dir, "my dir"), 0755)
+	_ = os.Rename(enc, filepath.Join(dir, "my dir", "values.yaml.enc"))
+
+	wd, _ := os.Getwd()
+	_ = os.Chdir(filepath.Join(dir, "my dir"))
+	defer func() { _ = os.Chdir(wd) }()
+
+	for _, uri := range []string{
+		fmt.Sprintf("keyvault+file://%s/my%%20dir/values.yaml.enc", filepath.ToSlash(dir)),
+		"keyvault+file://values.yaml.enc",
+		"keyvault+file://../my dir/values.yaml.enc",
+	} {
+		u, err := parseUri(uri)
+		assert.Nil(err, uri)
+		value, err := u.download()
+		assert.Nil(err, uri)
+		assert.Equal("key: value\n", value, uri)
+	}
+
+	// the resolved path is part of the error message
+	_, err := (&fileUri{"keyvault+file://../missing.yaml.enc"}).download()
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), filepath.Join("..", "missing.yaml.enc"))
+}
+
+func Test_certUri_PfxTerminal(t *testing.T) {
+	assert := assert.New(t)
+
+	isTerminal := stdoutIsTerminal
+	stdoutIsTerminal = func() bool { return true }
+	defer func() { stdoutIsTerminal = isTerminal }()
+
+	u := certUri{"keyvault+cert://mykeyvault/mycert?format=pfx"}
+	_, err := u.download()
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), "terminal", "should contain the reason")
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const defaultEditor = "vi"
+
+// secureTempFile - temporary file inside a private directory which gets
+// overwritten before it is removed
+type secureTempFile struct {
+	dir  string
+	path string
+	once sync.Once
+}
+
+// newSecureTempFile - create a file with the given name inside a new private (0700) directory
+func newSecureTempFile(name string, content string) (*secureTempFile, error) {
+	dir, err := os.MkdirTemp("", "helm-keyvault-")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &secureTempFile{dir: dir, path: filepath.Join(dir, filepath.Base(name))}
+	err = os.WriteFile(t.path, []byte(content), 0600)
+	if err != nil {
+		t.Cleanup()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Cleanup - overwrite the file content with zeros and remove the directory
+func (t *secureTempFile) Cleanup() {
+	t.once.Do(func() {
+		shredFile(t.path)
+		_ = os.RemoveAll(t.dir)
+	})
+}
+
+// shredFile - overwrite the given file with zeros before removing it
+func shredFile(f string) {
+	fi, err := os.Stat(f)
+	if err == nil {
+		fp, err := os.OpenFile(f, os.O_WRONLY, 0)
+		if err == nil {
+			_, _ = fp.Write(make([]byte, fi.Size()))
+			_ = fp.Sync()
+			_ = fp.Close()
+		}
+	}
+	_ = os.Remove(f)
+}
+
+// getEditor - return the users editor command ($VISUAL, $EDITOR or vi)
+func getEditor() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if e := strings.Fields(os.Getenv(env)); len(e) > 0 {
+			return e
+		}
+	}
+	return []string{defaultEditor}
+}
+
+// editString - write the given content into a private temporary file, open it in the users
+// editor and return the edited content. The temporary file is shredded afterwards, even
+// if the editor fails or the process is interrupted
+func editString(name string, content string) (string, bool, error) {
+
+	t, err := newSecureTempFile(name, content)
+	if err != nil {
+		return "", false, err
+	}
+	defer t.Cleanup()
+
+	// while the editor is running interrupts are handled by the editor itself (like git does).
+	// interrupts discard the changes, the deferred cleanup shreds the temporary file before exiting
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	interrupted := func() bool {
+		select {
+		case <-sigs:
+			return true
+		default:
+			return false
+		}
+	}
+
+	if interrupted() {
+		return "", false, errors.New("Editing interrupted")
+	}
+	e := getEditor()
+	c := exec.Command(e[0], append(e[1:], t.path)...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+
+	err = c.Run()
+	if err != nil {
+		return "", false, err
+	}
+	if interrupted() {
+		return "", false, errors.New("Editing interrupted, changes discarded")
+	}
+
+	edited, err := os.ReadFile(t.path)
+	if err != nil {
+		return "", false, err
+	}
+
+	return string(edited), string(edited) != content, nil
+}
@@ -1,13 +1,25 @@
 package cmd
 
 import (
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault"
 	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	log "github.com/sirupsen/logrus"
+	"os"
 	"strings"
 	"time"
 )
 
-// EncryptFile - encrypt the given file with the given key
-func EncryptFile(kv string, k string, v string, f string) error {
+// EncryptFile - encrypt the given file with the given key, the file content is
+// compressed with the given algorithm before it is encrypted.
+// In deterministic mode the existing encrypted file is decrypted, if it matches the content
+// it is kept as it is
+func EncryptFile(kv string, k string, v string, f string, c string, deterministic bool) error {
+
+	err := structs.ValidateCompression(c)
+	if err != nil {
+		return err
+	}
 
 	// initialzie keyvault
 	keyvault, err := structs.NewKeyVault(kv)
@@ -16,7 +28,7 @@ func EncryptFile(kv string, k string, v string, f string) error {
 	}
 
 	// setup encrypted file object
-	ef := structs.EncryptedFile{}
+	ef := structs.EncryptedFile{Compression: c}
 
 	// if version is empty we get the latest key version from
 	// the keyvault. this is required to ensure the file
@@ -34,7 +46,19 @@ func EncryptFile(kv string, k string, v string, f string) error {
 	ef.Kid = structs.NewKeyvaultObjectId(kv, "keys", k, v)
 
 	// load file
-	ef.EncodedData, err = ef.LoadFile(f)
+	content, err := os.ReadFile(f)
+	if err != nil {
+		return err
+	}
+
+	if deterministic {
+		if unchangedEncryptedFile(keyvault, fmt.Sprintf("%s.enc", f), ef, content) {
+			log.Infof("File %s unchanged, keeping %s.enc", f, f)
+			return nil
+		}
+	}
+
+	ef.EncodedData, err = ef.EncodeData(content)
 	if err != nil {
 		return err
 	}
@@ -51,6 +75,27 @@ func EncryptFile(kv string, k string, v string, f string) error {
 	return err
 }
 
+// unchangedEncryptedFile - returns true if the existing encrypted file uses the same key and
+// compression and its decrypted content matches the given content
+func unchangedEncryptedFile(kv keyvault.KeyvaultInterface, f string, ef structs.EncryptedFile, content []byte) bool {
+	existing := structs.EncryptedFile{}
+	existing, err := existing.LoadEncryptedFile(f)
+	if err != nil || existing.Kid != ef.Kid || existing.Compression != ef.Compression {
+		return false
+	}
+
+	kid, err := existing.Kid.ParseType("keys")
+	if err != nil {
+		return false
+	}
+	existing.EncodedData, err = existing.DecryptData(kv, kid.Name, kid.Version)
+	if err != nil {
+		return false
+	}
+	decrypted, err := existing.GetDecodedString()
+	return err == nil && decrypted == string(content)
+}
+
 // DecryptFile - decrypt the given file with the key specified in the encrypted
 // file. The keyvault and namespace can be overwritten via paraeters/env vars
 func DecryptFile(kv string, k string, v string, f string) error {
@@ -62,8 +107,13 @@ func DecryptFile(kv string, k string, v string, f string) error {
 		return err
 	}
 
+	kid, err := ef.Kid.ParseType("keys")
+	if err != nil {
+		return err
+	}
+
 	// overwrite keyvault, key and version if required
-	keyvault, err := structs.NewKeyVault(ef.Kid.GetKeyvault())
+	keyvault, err := structs.NewKeyVault(kid.Keyvault)
 	if kv != "" {
 		keyvault, err = structs.NewKeyVault(kv)
 	}
@@ -71,12 +121,12 @@ func DecryptFile(kv string, k string, v string, f string) error {
 		return err
 	}
 
-	key := ef.Kid.GetName()
+	key := kid.Name
 	if k != "" {
 		key = k
 	}
 
-	version := ef.Kid.GetVersion()
+	version := kid.Version
 	if v != "" {
 		version = v
 	}
@@ -93,3 +143,61 @@ func DecryptFile(kv string, k string, v string, f string) error {
 	return err
 
 }
+
+// EditFile - decrypt the given file into a private temporary file, open it in the users
+// editor and re-encrypt it with the same key if the content was changed
+func EditFile(f string) error {
+
+	// load and decrypt encrypted file
+	ef := structs.EncryptedFile{}
+	ef, err := ef.LoadEncryptedFile(f)
+	if err != nil {
+		return err
+	}
+
+	kid, err := ef.Kid.ParseType("keys")
+	if err != nil {
+		return err
+	}
+	keyvault, err := structs.NewKeyVault(kid.Keyvault)
+	if err != nil {
+		return err
+	}
+
+	key := kid.Name
+	version := kid.Version
+	ef.EncodedData, err = ef.DecryptData(keyvault, key, version)
+	if err != nil {
+		return err
+	}
+
+	original, err := ef.GetDecodedString()
+	if err != nil {
+		return err
+	}
+
+	// edit the decrypted content, the temporary file is named like the original file
+	// to allow the editor to detect the file type
+	edited, changed, err := editString(strings.TrimSuffix(f, ".enc"), original)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		log.Infof("File %s unchanged", f)
+		return nil
+	}
+
+	// re-encrypt the edited content with the same key, version and compression
+	ef.EncodedData, err = ef.EncodeData([]byte(edited))
+	if err != nil {
+		return err
+	}
+	ef.EncryptedData, err = ef.EncryptData(keyvault, key, version)
+	if err != nil {
+		return err
+	}
+	ef.LastModified = structs.JTime(time.Now())
+
+	err = ef.SaveEncryptedFile(f)
+	return err
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeEditor - write an editor script which runs the given shell command against the file to edit
+// and logs the path of the edited file into the returned log file
+func writeEditor(t *testing.T, command string) (string, string) {
+	dir := t.TempDir()
+	editor := filepath.Join(dir, "editor.sh")
+	logfile := filepath.Join(dir, "editor.log")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$1\" > %s\n%s\n", logfile, command)
+	_ = os.WriteFile(editor, []byte(script), 0755)
+	return editor, logfile
+}
+
+// writeMockEncryptedFile - write an encrypted file, the mock keyvault doesnt encrypt the chunks
+func writeMockEncryptedFile(t *testing.T, content string) string {
+	return writeNamedMockEncryptedFile(t, "values.yaml", content)
+}
+
+// writeNamedMockEncryptedFile - write an encrypted file with the given decrypted file name
+func writeNamedMockEncryptedFile(t *testing.T, name string, content string) string {
+	f := filepath.Join(t.TempDir(), name)
+	ef := structs.EncryptedFile{
+		Kid:          structs.NewKeyvaultObjectId("mykeyvault", "keys", "mykey", "myversion"),
+		LastModified: structs.JTime(time.Date(2021, 12, 31, 12, 0, 0, 0, time.UTC)),
+	}
+	ef.EncryptedData, _ = ef.EncodeData([]byte(content))
+	_ = ef.WriteEncryptedFile(f)
+	return fmt.Sprintf("%s.enc", f)
+}
+
+func Test_EditFile(t *testing.T) {
+	assert := assert.New(t)
+
+	structs.NewKeyVault = newMockKeyVault
+	f := writeMockEncryptedFile(t, "key: value\n")
+	editor, logfile := writeEditor(t, "echo 'other: value' >> \"$1\"")
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", editor)
+
+	err := EditFile(f)
+	assert.Nil(err, "should be nil")
+
+	// the file should be re-encrypted with the same key
+	ef := structs.EncryptedFile{}
+	ef, err = ef.LoadEncryptedFile(f)
+	assert.Nil(err, "should be nil")
+	assert.Equal(structs.NewKeyvaultObjectId("mykeyvault", "keys", "mykey", "myversion"), ef.Kid)
+	ef.EncodedData = ef.EncryptedData
+	dec, _ := ef.GetDecodedString()
+	assert.Equal("key: value\nother: value\n", dec, "should be equal")
+
+	// the temporary file should be named like the decrypted file and removed afterwards
+	tmp, _ := ioutil.ReadFile(logfile)
+	tmpfile := strings.TrimSpace(string(tmp))
+	assert.Equal("values.yaml", filepath.Base(tmpfile))
+	assert.NoFileExists(tmpfile, "should be removed")
+}
+
+func Test_EditFile_EncInName(t *testing.T) {
+	assert := assert.New(t)
+
+	structs.NewKeyVault = newMockKeyVault
+	f := writeNamedMockEncryptedFile(t, "app.enc.yaml", "key: value\n")
+	editor, logfile := writeEditor(t, "true")
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", editor)
+
+	err := EditFile(f)
+	assert.Nil(err, "should be nil")
+
+	// only the trailing .enc extension should be removed from the temporary file name
+	tmp, _ := ioutil.ReadFile(logfile)
+	assert.Equal("app.enc.yaml", filepath.Base(strings.TrimSpace(string(tmp))))
+}
+
+func Test_EditFile_Unchanged(t *testing.T) {
+	assert := assert.New(t)
+
+	structs.NewKeyVault = newMockKeyVault
+	f := writeMockEncryptedFile(t, "key: value\n")
+	before, _ := os.ReadFile(f)
+	editor, _ := writeEditor(t, "true")
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", editor)
+
+	err := EditFile(f)
+	assert.Nil(err, "should be nil")
+
+	after, _ := os.ReadFile(f)
+	assert.Equal(string(before), string(after), "should be equal")
+}
+
+func Test_EditFile_EditorFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	structs.NewKeyVault = newMockKeyVault
+	f := writeMockEncryptedFile(t, "key: value\n")
+	before, _ := os.ReadFile(f)
+	editor, logfile := writeEditor(t, "echo 'other: value' >> \"$1\"; exit 1")
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", editor)
+
+	err := EditFile(f)
+	assert.Error(err, "should be error")
+
+	// the encrypted file stays untouched, the temporary file is removed
+	after, _ := os.ReadFile(f)
+	assert.Equal(string(before), string(after), "should be equal")
+	tmp, _ := ioutil.ReadFile(logfile)
+	assert.NoFileExists(strings.TrimSpace(string(tmp)), "should be removed")
+}
+
+func Test_EditFile_Interrupted(t *testing.T) {
+	assert := assert.New(t)
+
+	structs.NewKeyVault = newMockKeyVault
+	f := writeMockEncryptedFile(t, "key: value\n")
+	before, _ := os.ReadFile(f)
+	// the editor interrupts the plugin while it is running
+	editor, logfile := writeEditor(t, "echo 'other: value' >> \"$1\"; kill -INT $PPID; sleep 0.2")
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", editor)
+
+	err := EditFile(f)
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), "interrupted", "should contain the reason")
+
+	// the changes are discarded, the temporary file is removed
+	after, _ := os.ReadFile(f)
+	assert.Equal(string(before), string(after), "should be equal")
+	tmp, _ := ioutil.ReadFile(logfile)
+	assert.NoFileExists(strings.TrimSpace(string(tmp)), "should be removed")
+}
+
+func Test_EncryptFile_Deterministic(t *testing.T) {
+	assert := assert.New(t)
+
+	structs.NewKeyVault = newMockKeyVault
+	f := filepath.Join(t.TempDir(), "values.yaml")
+	_ = os.WriteFile(f, []byte("key: value\n"), 0644)
+
+	err := EncryptFile("mykeyvault", "mykey", "myversion", f, "", true)
+	assert.Nil(err, "should be nil")
+	first, _ := os.ReadFile(fmt.Sprintf("%s.enc", f))
+	assert.NotContains(string(first), "checksum", "should not contain a checksum of the content")
+
+	// unchanged content keeps the encrypted file byte-identical
+	err = EncryptFile("mykeyvault", "mykey", "myversion", f, "", true)
+	assert.Nil(err, "should be nil")
+	second, _ := os.ReadFile(fmt.Sprintf("%s.enc", f))
+	assert.Equal(string(first), string(second), "should be equal")
+
+	// changed content or a different key version re-encrypts the file
+	_ = os.WriteFile(f, []byte("key: other\n"), 0644)
+	err = EncryptFile("mykeyvault", "mykey", "myversion", f, "", true)
+	assert.Nil(err, "should be nil")
+	third, _ := os.ReadFile(fmt.Sprintf("%s.enc", f))
+	assert.NotEqual(string(second), string(third), "should not be equal")
+
+	err = EncryptFile("mykeyvault", "mykey", "otherversion", f, "", true)
+	assert.Nil(err, "should be nil")
+	fourth, _ := os.ReadFile(fmt.Sprintf("%s.enc", f))
+	assert.NotEqual(string(third), string(fourth), "should not be equal")
+}
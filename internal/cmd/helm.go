@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// HelmCommands - helm commands wrapped by the plugin
+var HelmCommands = []string{"install", "upgrade", "template", "diff"}
+
+// ErrInterrupted - the helm command was interrupted before helm was started
+var ErrInterrupted = errors.New("Interrupted")
+
+// helmTempFiles - decrypted values files passed to helm
+type helmTempFiles struct {
+	sync.Mutex
+	files []*secureTempFile
+}
+
+func (h *helmTempFiles) add(t *secureTempFile) {
+	h.Lock()
+	defer h.Unlock()
+	h.files = append(h.files, t)
+}
+
+func (h *helmTempFiles) Cleanup() {
+	h.Lock()
+	defer h.Unlock()
+	for _, t := range h.files {
+		t.Cleanup()
+	}
+}
+
+// Helm - run the given helm command, encrypted values files passed with -f/--values are
+// decrypted into private temporary files which are removed after helm exits. The directory of
+// a local chart is passed to the downloader plugin to resolve keyvault+file uris with base=chart
+func Helm(command string, args []string) error {
+
+	tmp := &helmTempFiles{}
+	defer tmp.Cleanup()
+
+	// signals are forwarded to helm while it is running. a signal before helm is started
+	// aborts the command once the values are decrypted, the deferred cleanup removes the files
+	var helm *exec.Cmd
+	var mu sync.Mutex
+	interrupted := false
+	sigs := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer func() {
+		signal.Stop(sigs)
+		close(done)
+	}()
+	go func() {
+		for {
+			select {
+			case s := <-sigs:
+				mu.Lock()
+				if helm == nil || helm.Process == nil {
+					interrupted = true
+				} else {
+					_ = helm.Process.Signal(s)
+				}
+				mu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	rewritten, err := decryptValuesArgs(args, tmp)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	if interrupted {
+		mu.Unlock()
+		return ErrInterrupted
+	}
+	helm = exec.Command(helmBin(), append([]string{command}, rewritten...)...)
+	helm.Stdin = os.Stdin
+	helm.Stdout = os.Stdout
+	helm.Stderr = os.Stderr
+	helm.Env = os.Environ()
+	if dir := chartDir(args); dir != "" && os.Getenv(chartDirEnv) == "" {
+		helm.Env = append(helm.Env, fmt.Sprintf("%s=%s", chartDirEnv, dir))
+	}
+	err = helm.Start()
+	mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return helm.Wait()
+}
+
+// helmBin - return the helm binary, helm sets $HELM_BIN when running plugins
+func helmBin() string {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		return h
+	}
+	return "helm"
+}
+
+// chartDir - return the absolute path of the first argument which is a local chart directory
+func chartDir(args []string) string {
+	for _, a := range args {
+		if strings.HasPrefix(a, "-") {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(a, "Chart.yaml"))
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		dir, err := filepath.Abs(a)
+		if err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+// decryptValuesArgs - replace encrypted files passed with -f/--values by decrypted temporary files
+func decryptValuesArgs(args []string, tmp *helmTempFiles) ([]string, error) {
+	var rewritten []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+
+		// flag and value are either passed as separate arguments or as one argument
+		var flag, value string
+		separate := false
+		switch {
+		case a == "--":
+			return append(rewritten, args[i:]...), nil
+		case (a == "-f" || a == "--values") && i+1 < len(args):
+			flag = a
+			value = args[i+1]
+			separate = true
+			i++
+		case strings.HasPrefix(a, "--values="):
+			flag = "--values="
+			value = strings.TrimPrefix(a, "--values=")
+		case strings.HasPrefix(a, "-f="):
+			flag = "-f="
+			value = strings.TrimPrefix(a, "-f=")
+		case strings.HasPrefix(a, "-f") && len(a) > 2 && !strings.HasPrefix(a, "--"):
+			flag = "-f"
+			value = strings.TrimPrefix(a, "-f")
+		default:
+			rewritten = append(rewritten, a)
+			continue
+		}
+
+		// helm accepts a comma separated list of files
+		files := strings.Split(value, ",")
+		for j, f := range files {
+			decrypted, err := decryptValuesFile(f, tmp)
+			if err != nil {
+				return nil, err
+			}
+			files[j] = decrypted
+		}
+		value = strings.Join(files, ",")
+
+		if separate {
+			rewritten = append(rewritten, flag, value)
+		} else {
+			rewritten = append(rewritten, flag+value)
+		}
+	}
+	return rewritten, nil
+}
+
+// decryptValuesFile - decrypt the given file into a temporary file if it is an encrypted file,
+// the path of other files and urls is returned unchanged
+func decryptValuesFile(f string, tmp *helmTempFiles) (string, error) {
+	if !isEncryptedFile(f) {
+		return f, nil
+	}
+
+	content, err := decryptFileContent(f)
+	if err != nil {
+		return "", fmt.Errorf("Unable to decrypt %s: %v", f, err)
+	}
+
+	t, err := newSecureTempFile(strings.TrimSuffix(filepath.Base(f), ".enc"), content)
+	if err != nil {
+		return "", err
+	}
+	tmp.add(t)
+	return t.path, nil
+}
+
+// isEncryptedFile - returns true if the given file is an encrypted file
+func isEncryptedFile(f string) bool {
+	fi, err := os.Stat(f)
+	if err != nil || fi.IsDir() {
+		return false
+	}
+	ef := structs.EncryptedFile{}
+	ef, err = ef.LoadEncryptedFile(f)
+	return err == nil && ef.Kid != "" && len(ef.EncryptedData) > 0
+}
+
+// decryptFileContent - decrypt the given file with the key stored in the file
+func decryptFileContent(f string) (string, error) {
+	ef := structs.EncryptedFile{}
+	ef, err := ef.LoadEncryptedFile(f)
+	if err != nil {
+		return "", err
+	}
+	return decryptEncryptedFile(ef)
+}
+
+// decryptEncryptedFile - decrypt the given encrypted file with the key stored in the file
+func decryptEncryptedFile(ef structs.EncryptedFile) (string, error) {
+	kid, err := ef.Kid.ParseType("keys")
+	if err != nil {
+		return "", err
+	}
+	keyvault, err := structs.NewKeyVault(kid.Keyvault)
+	if err != nil {
+		return "", err
+	}
+
+	ef.EncodedData, err = ef.DecryptData(keyvault, kid.Name, kid.Version)
+	if err != nil {
+		return "", err
+	}
+	return ef.GetDecodedString()
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_decryptValuesArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	structs.NewKeyVault = newMockKeyVault
+	enc := writeMockEncryptedFile(t, "key: value\n")
+	plain := filepath.Join(t.TempDir(), "plain.yaml")
+	_ = os.WriteFile(plain, []byte("key: value\n"), 0644)
+
+	tmp := &helmTempFiles{}
+	args := []string{
+		"example", "chart/",
+		"-f", enc,
+		"--values", plain,
+		"--values=" + enc,
+		"-f=" + plain + "," + enc,
+		"-f" + enc,
+		"--set", "key=value",
+		"-f", "keyvault+file://" + enc,
+		"--", "-f", enc,
+	}
+	rewritten, err := decryptValuesArgs(args, tmp)
+	assert.Nil(err, "should be nil")
+	assert.Len(tmp.files, 4, "should be 4")
+
+	expected := []string{
+		"example", "chart/",
+		"-f", tmp.files[0].path,
+		"--values", plain,
+		"--values=" + tmp.files[1].path,
+		"-f=" + plain + "," + tmp.files[2].path,
+		"-f" + tmp.files[3].path,
+		"--set", "key=value",
+		"-f", "keyvault+file://" + enc,
+		"--", "-f", enc,
+	}
+	assert.Equal(expected, rewritten, "should be equal")
+
+	for _, f := range tmp.files {
+		assert.Equal("values.yaml", filepath.Base(f.path), "should be equal")
+		content, _ := os.ReadFile(f.path)
+		assert.Equal("key: value\n", string(content), "should be equal")
+	}
+
+	tmp.Cleanup()
+	for _, f := range tmp.files {
+		assert.NoFileExists(f.path, "should be removed")
+	}
+}
+
+func Test_Helm(t *testing.T) {
+	assert := assert.New(t)
+
+	structs.NewKeyVault = newMockKeyVault
+	enc := writeMockEncryptedFile(t, "key: value\n")
+
+	// the fake helm binary logs its arguments and the content of the values file
+	dir := t.TempDir()
+	logfile := filepath.Join(dir, "helm.log")
+	helm := filepath.Join(dir, "helm.sh")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %s\ncat \"$4\" >> %s\nexit 3\n", logfile, logfile)
+	_ = os.WriteFile(helm, []byte(script), 0755)
+	t.Setenv("HELM_BIN", helm)
+
+	err := Helm("template", []string{"example", "-f", enc})
+	assert.Error(err, "should be error")
+
+	log, _ := os.ReadFile(logfile)
+	lines := strings.Split(string(log), "\n")
+	args := strings.Fields(lines[0])
+	assert.Equal([]string{"template", "example", "-f"}, args[:3], "should be equal")
+	assert.Equal("key: value", lines[1], "should be equal")
+
+	// the decrypted file is removed after helm exits
+	assert.NoFileExists(args[3], "should be removed")
+}
+
+func Test_Helm_ChartDir(t *testing.T) {
+	assert := assert.New(t)
+
+	// the fake helm binary logs the chart directory passed to the downloader plugin
+	dir := t.TempDir()
+	logfile := filepath.Join(dir, "helm.log")
+	helm := filepath.Join(dir, "helm.sh")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$%s\" > %s\n", chartDirEnv, logfile)
+	_ = os.WriteFile(helm, []byte(script), 0755)
+	t.Setenv("HELM_BIN", helm)
+	t.Setenv(chartDirEnv, "")
+
+	chart := filepath.Join(dir, "chart")
+	_ = os.MkdirAll(chart, 0755)
+	_ = os.WriteFile(filepath.Join(chart, "Chart.yaml"), []byte("name: example\n"), 0644)
+
+	err := Helm("template", []string{"example", "--namespace", dir, chart})
+	assert.Nil(err, "should be nil")
+	log, _ := os.ReadFile(logfile)
+	assert.Equal(chart+"\n", string(log), "should be the chart directory")
+
+	assert.Equal("", chartDir([]string{"example", "private/example", dir}), "should be empty")
+}
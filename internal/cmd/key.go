@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	log "github.com/sirupsen/logrus"
 )
 
 // ListKeys - List all secrets in the keyvault
@@ -64,3 +65,112 @@ func CreateKey(kv string, k string) error {
 	return nil
 
 }
+
+// DeleteKey - Delete all versions of the key
+func DeleteKey(kv string, k string, yes bool) error {
+
+	err := confirm(fmt.Sprintf("Delete key %s from keyvault %s? Files encrypted with the key can't be decrypted anymore", k, kv), yes)
+	if err != nil {
+		return err
+	}
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	key := structs.NewKey(keyvault, k, "")
+	d, err := key.Delete()
+	if err != nil {
+		return err
+	}
+	return printDeleted("Key", d)
+}
+
+// ListDeletedKeys - List all deleted keys of a keyvault with soft-delete enabled
+func ListDeletedKeys(kv string) error {
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	kl := structs.KeyList{}
+	dl := structs.DeletedList{}
+	dl.Deleted, err = kl.Deleted(keyvault)
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
+
+// RecoverKey - Recover a deleted key
+func RecoverKey(kv string, k string) error {
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	key := structs.NewKey(keyvault, k, "")
+	key, err = key.Recover()
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
+
+// PurgeKey - Permanently delete a deleted key
+func PurgeKey(kv string, k string, yes bool) error {
+
+	err := confirm(fmt.Sprintf("Permanently delete key %s from keyvault %s? The key can't be recovered", k, kv), yes)
+	if err != nil {
+		return err
+	}
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	key := structs.NewKey(keyvault, k, "")
+	err = key.Purge()
+	if err != nil {
+		return err
+	}
+	log.Infof("Key %s purged", k)
+	return nil
+}
+
+// RestoreKey - Restore a key from the given backup file
+func RestoreKey(kv string, f string) error {
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	key, err := structs.RestoreKey(keyvault, f)
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// placeholders for secrets inside rendered manifests: keyvault+secret://<keyvault>/<secret>[/<version>].
+// keyvault versions consist of 32 hex characters, other path segments following the secret arent part of the placeholder
+var secretPlaceholder = regexp.MustCompile(`keyvault\+secrets?://[a-zA-Z0-9.-]+(/secrets)?/[a-zA-Z0-9-]+(/[0-9a-fA-F]{32})?`)
+
+// placeholderNode - yaml scalar containing secret placeholders
+type placeholderNode struct {
+	node *yaml.Node
+	// values inside the data field of kubernetes secrets need to be base64 encoded
+	encode bool
+}
+
+// PostRender - read rendered manifests from stdin, replace all secret placeholders and write them to stdout
+func PostRender() error {
+	return postRender(os.Stdin, os.Stdout)
+}
+
+// postRender - replace all secret placeholders in the given manifests
+func postRender(in io.Reader, out io.Writer) error {
+
+	var docs []*yaml.Node
+	dec := yaml.NewDecoder(in)
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// skip empty documents
+		if len(doc.Content) == 0 || (doc.Content[0].Tag == "!!null" && doc.Content[0].Value == "") {
+			continue
+		}
+		docs = append(docs, &doc)
+	}
+
+	r := newSecretResolver()
+	var placeholders []placeholderNode
+	for _, doc := range docs {
+		for _, p := range findPlaceholders(doc.Content[0]) {
+			for _, m := range secretPlaceholder.FindAllString(p.node.Value, -1) {
+				_, err := r.add(placeholderRef(m))
+				if err != nil {
+					return err
+				}
+			}
+			placeholders = append(placeholders, p)
+		}
+	}
+
+	err := r.resolve()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range placeholders {
+		value := secretPlaceholder.ReplaceAllStringFunc(p.node.Value, func(m string) string {
+			ref, _ := r.add(placeholderRef(m))
+			return r.value(ref)
+		})
+		if p.encode {
+			value = base64.StdEncoding.EncodeToString([]byte(value))
+		}
+		p.node.Value = value
+		p.node.Tag = "!!str"
+		p.node.Style = 0
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	for _, doc := range docs {
+		err = enc.Encode(doc)
+		if err != nil {
+			return err
+		}
+	}
+	err = enc.Close()
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write(buf.Bytes())
+	return err
+}
+
+// placeholderRef - strip the scheme from the placeholder
+func placeholderRef(placeholder string) string {
+	return placeholder[strings.Index(placeholder, "://")+len("://"):]
+}
+
+// findPlaceholders - return all scalars of the manifest containing secret placeholders
+func findPlaceholders(manifest *yaml.Node) []placeholderNode {
+
+	// the data field of kubernetes secrets contains base64 encoded values
+	var data *yaml.Node
+	if kind := mappingValue(manifest, "kind"); kind != nil && kind.Value == "Secret" {
+		data = mappingValue(manifest, "data")
+	}
+
+	var placeholders []placeholderNode
+	var walk func(n *yaml.Node, encode bool)
+	walk = func(n *yaml.Node, encode bool) {
+		if n.Kind == yaml.ScalarNode && secretPlaceholder.MatchString(n.Value) {
+			placeholders = append(placeholders, placeholderNode{node: n, encode: encode})
+		}
+		for _, c := range n.Content {
+			walk(c, encode || n == data)
+		}
+	}
+	walk(manifest, false)
+
+	return placeholders
+}
+
+// mappingValue - return the value node of the given key
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func Test_postRender(t *testing.T) {
+	assert := assert.New(t)
+
+	structs.NewKeyVault = newValuesMockKeyVault
+	defer func() { structs.NewKeyVault = newMockKeyVault }()
+
+	manifests := `apiVersion: v1
+kind: Secret
+metadata:
+  name: keyvault+secret://mykeyvault/myapp-db-user
+data:
+  password: keyvault+secret://mykeyvault/myapp-db-password
+stringData:
+  url: postgres://keyvault+secret://mykeyvault/myapp-db-user:keyvault+secrets://mykeyvault.vault.azure.net/secrets/myapp-db-password/0f219949d08b459b80c7fcdaf2d56abd@db
+  api: https://keyvault+secret://mykeyvault/myapp-token/api/v1
+---
+---
+apiVersion: v1
+kind: ConfigMap
+data:
+  token: keyvault+secret://mykeyvault/myapp-token
+  other: value
+`
+	expected := `apiVersion: v1
+kind: Secret
+metadata:
+  name: user
+data:
+  password: cGFzc3dvcmQ=
+stringData:
+  url: postgres://user:password@db
+  api: https://token/api/v1
+---
+apiVersion: v1
+kind: ConfigMap
+data:
+  token: token
+  other: value
+`
+
+	var out bytes.Buffer
+	err := postRender(strings.NewReader(manifests), &out)
+	assert.Nil(err, "should be nil")
+	assert.Equal(expected, out.String(), "should be equal")
+
+	// invalid keyvault name
+	err = postRender(strings.NewReader("key: keyvault+secret://a/mysecret\n"), &out)
+	assert.Error(err, "should be error")
+	err = postRender(strings.NewReader("key: [invalid\n"), &out)
+	assert.Error(err, "should be error")
+}
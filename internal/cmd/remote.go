@@ -0,0 +1,333 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// accepted oci manifest media types
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	// annotation with the file name of an oci layer
+	ociTitleAnnotation = "org.opencontainers.image.title"
+	// maximum size of a remote encrypted file
+	maxRemoteFileSize = 32 << 20
+)
+
+// TLSFiles - client certificate, key and ca file passed to the downloader by helm
+type TLSFiles struct {
+	CertFile string
+	KeyFile  string
+	CaFile   string
+}
+
+// remoteFileUri - represents an keyvault+file+https or keyvault+file+oci uri
+type remoteFileUri struct {
+	uri string
+	tls TLSFiles
+}
+
+func (u *remoteFileUri) download() (string, error) {
+
+	client, err := newHttpClient(u.tls)
+	if err != nil {
+		return "", err
+	}
+
+	// keyvault+file+https://... -> https://...
+	remote := strings.TrimPrefix(u.uri, "keyvault+file+")
+
+	var content []byte
+	if strings.HasPrefix(remote, "oci://") {
+		content, err = fetchOci(client, remote)
+	} else {
+		content, err = fetchHttps(client, remote)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Unable to download %s: %v", remote, err)
+	}
+
+	ef := structs.EncryptedFile{}
+	ef, err = ef.ParseEncryptedFile(content)
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse encrypted file %s: %v", remote, err)
+	}
+	value, err := decryptEncryptedFile(ef)
+	if err != nil {
+		return "", fmt.Errorf("Unable to decrypt %s: %v", remote, err)
+	}
+	return value, nil
+}
+
+// newHttpClient - return a http client using the given client certificate and ca
+func newHttpClient(files TLSFiles) (*http.Client, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if files.CertFile != "" && files.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if files.CaFile != "" {
+		ca, err := os.ReadFile(files.CaFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load ca file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("No certificates found in ca file %s", files.CaFile)
+		}
+		config.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport, Timeout: 60 * time.Second}, nil
+}
+
+// fetchHttps - download the given https url, e.g. a file in a git repository or an azure blob with sas token
+func fetchHttps(client *http.Client, u string) ([]byte, error) {
+	if !strings.HasPrefix(u, "https://") {
+		return nil, errors.New("Only https urls are supported")
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	// azure blob storage requires the api version for anonymous and sas requests
+	req.Header.Set("x-ms-version", "2020-04-08")
+	return doRequest(client, req)
+}
+
+// doRequest - execute the request and return the response body
+func doRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return readResponse(resp)
+}
+
+// readResponse - return the body of a successful response
+func readResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxRemoteFileSize {
+		return nil, fmt.Errorf("File exceeds the maximum size of %d bytes", maxRemoteFileSize)
+	}
+	return body, nil
+}
+
+// ociReference - oci artifact reference in the form registry/repository[:tag|@digest][?file=name]
+type ociReference struct {
+	Registry   string
+	Repository string
+	Reference  string
+	File       string
+}
+
+// parseOciReference - parse the given oci:// uri
+func parseOciReference(uri string) (ociReference, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ociReference{}, err
+	}
+
+	ref := ociReference{Registry: u.Host, File: u.Query().Get("file"), Reference: "latest"}
+	repo := strings.TrimPrefix(u.Path, "/")
+	if i := strings.Index(repo, "@"); i >= 0 {
+		ref.Reference = repo[i+1:]
+		repo = repo[:i]
+	} else if i := strings.LastIndex(repo, ":"); i >= 0 {
+		ref.Reference = repo[i+1:]
+		repo = repo[:i]
+	}
+	ref.Repository = repo
+
+	if ref.Registry == "" || ref.Repository == "" || ref.Reference == "" {
+		return ociReference{}, fmt.Errorf("Invalid oci reference '%s', use oci://registry/repository[:tag|@digest]", uri)
+	}
+	return ref, nil
+}
+
+var ociDigest = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// ociManifest - the parts of an oci manifest required to find the encrypted file
+type ociManifest struct {
+	Layers []struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"layers"`
+}
+
+// fetchOci - download the encrypted file stored as layer of an oci artifact. Artifacts with multiple
+// layers require the file query parameter matching the layers title annotation. The downloaded layer
+// is verified against its digest
+func fetchOci(client *http.Client, uri string) ([]byte, error) {
+	ref, err := parseOciReference(uri)
+	if err != nil {
+		return nil, err
+	}
+	r := ociRegistry{client: client, ref: ref}
+
+	body, err := r.get(fmt.Sprintf("manifests/%s", ref.Reference), strings.Join([]string{ociManifestMediaType, dockerManifestMediaType}, ", "))
+	if err != nil {
+		return nil, err
+	}
+	var manifest ociManifest
+	err = json.Unmarshal(body, &manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := ""
+	for _, l := range manifest.Layers {
+		if ref.File == "" || l.Annotations[ociTitleAnnotation] == ref.File {
+			if digest != "" {
+				return nil, errors.New("Artifact contains multiple files, select one with the file query parameter")
+			}
+			digest = l.Digest
+		}
+	}
+	if digest == "" {
+		return nil, fmt.Errorf("Artifact doesnt contain the file '%s'", ref.File)
+	}
+	if !ociDigest.MatchString(digest) {
+		return nil, fmt.Errorf("Unsupported layer digest '%s', only sha256 digests are supported", digest)
+	}
+
+	blob, err := r.get(fmt.Sprintf("blobs/%s", digest), "")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(blob)
+	if fmt.Sprintf("sha256:%s", hex.EncodeToString(sum[:])) != digest {
+		return nil, fmt.Errorf("Layer doesnt match its digest %s", digest)
+	}
+	return blob, nil
+}
+
+// ociRegistry - minimal client for the oci distribution api with support for anonymous
+// and basic auth ($HELM_KEYVAULT_OCI_USERNAME, $HELM_KEYVAULT_OCI_PASSWORD) bearer tokens
+type ociRegistry struct {
+	client *http.Client
+	ref    ociReference
+	token  string
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// get - retrieve the given path of the repository, authenticating if the registry requires it
+func (r *ociRegistry) get(path string, accept string) ([]byte, error) {
+	u := fmt.Sprintf("https://%s/v2/%s/%s", r.ref.Registry, r.ref.Repository, path)
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if r.token != "" {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", r.token))
+		}
+
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return readResponse(resp)
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+
+		err = r.authenticate(challenge)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// authenticate - retrieve a bearer token for the given challenge. The registry is always accessed via https,
+// the realm has to use https as well to not send the credentials in plaintext
+func (r *ociRegistry) authenticate(challenge string) error {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return fmt.Errorf("Unsupported registry authentication '%s'", challenge)
+	}
+	params := map[string]string{}
+	for _, m := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	if params["realm"] == "" {
+		return errors.New("Registry authentication without realm")
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Scheme != "https" || realm.Host == "" {
+		return fmt.Errorf("Registry authentication realm '%s' doesnt use https", params["realm"])
+	}
+
+	q := url.Values{}
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	if params["scope"] != "" {
+		q.Set("scope", params["scope"])
+	} else {
+		q.Set("scope", fmt.Sprintf("repository:%s:pull", r.ref.Repository))
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s?%s", params["realm"], q.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	if user := os.Getenv("HELM_KEYVAULT_OCI_USERNAME"); user != "" {
+		req.SetBasicAuth(user, os.Getenv("HELM_KEYVAULT_OCI_PASSWORD"))
+	}
+
+	body, err := doRequest(r.client, req)
+	if err != nil {
+		return fmt.Errorf("Unable to retrieve registry token: %v", err)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return err
+	}
+	r.token = token.Token
+	if r.token == "" {
+		r.token = token.AccessToken
+	}
+	return nil
+}
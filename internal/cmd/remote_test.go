@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newRemoteServer - tls server serving an encrypted file via https and as oci artifact
+func newRemoteServer(t *testing.T, content []byte) (*httptest.Server, string) {
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+
+	mux.HandleFunc("/files/values.yaml.enc", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(content)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "repository:config/values:pull", r.URL.Query().Get("scope"))
+		_, _ = w.Write([]byte(`{"token": "mytoken"}`))
+	})
+	authorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Authorization") != "Bearer mytoken" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:config/values:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	digest := func(c []byte) string {
+		sum := sha256.Sum256(c)
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	// the layer other.yaml.enc doesnt match its digest
+	values, other := digest(content), digest([]byte("other"))
+	mux.HandleFunc("/v2/config/values/manifests/v1", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) {
+			_, _ = w.Write([]byte(fmt.Sprintf(`{"layers": [
+				{"digest": "%s", "annotations": {"org.opencontainers.image.title": "other.yaml.enc"}},
+				{"digest": "%s", "annotations": {"org.opencontainers.image.title": "values.yaml.enc"}},
+				{"digest": "md5:1234", "annotations": {"org.opencontainers.image.title": "md5.yaml.enc"}}
+			]}`, other, values)))
+		}
+	})
+	for _, d := range []string{values, other} {
+		mux.HandleFunc("/v2/config/values/blobs/"+d, func(w http.ResponseWriter, r *http.Request) {
+			if authorized(w, r) {
+				_, _ = w.Write(content)
+			}
+		})
+	}
+	// credentials arent sent to realms without https
+	mux.HandleFunc("/v2/config/insecure/manifests/v1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token"`, strings.Replace(server.URL, "https://", "http://", 1)))
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	_ = os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+	return server, ca
+}
+
+func Test_remoteFileUri_download(t *testing.T) {
+	assert := assert.New(t)
+
+	structs.NewKeyVault = newMockKeyVault
+	content, _ := os.ReadFile(writeMockEncryptedFile(t, "key: value\n"))
+	server, ca := newRemoteServer(t, content)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	for _, uri := range []string{
+		fmt.Sprintf("keyvault+file+https://%s/files/values.yaml.enc", host),
+		fmt.Sprintf("keyvault+file+oci://%s/config/values:v1?file=values.yaml.enc", host),
+	} {
+		u, err := parseUri(uri)
+		assert.Nil(err, uri)
+		assert.IsType(&remoteFileUri{}, u)
+		u.(*remoteFileUri).tls = TLSFiles{CaFile: ca}
+
+		value, err := u.download()
+		assert.Nil(err, uri)
+		assert.Equal("key: value\n", value, uri)
+
+		// the server certificate isnt trusted without the ca file
+		u.(*remoteFileUri).tls = TLSFiles{}
+		_, err = u.download()
+		assert.Error(err, uri)
+	}
+
+	for _, uri := range []string{
+		fmt.Sprintf("keyvault+file+https://%s/files/missing.yaml.enc", host),
+		fmt.Sprintf("keyvault+file+oci://%s/config/values:v1", host),
+		fmt.Sprintf("keyvault+file+oci://%s/config/values:v1?file=missing.yaml.enc", host),
+		fmt.Sprintf("keyvault+file+oci://%s/config/values:v2", host),
+	} {
+		_, err := (&remoteFileUri{uri: uri, tls: TLSFiles{CaFile: ca}}).download()
+		assert.Error(err, uri)
+	}
+
+	for uri, reason := range map[string]string{
+		fmt.Sprintf("keyvault+file+oci://%s/config/values:v1?file=other.yaml.enc", host): "doesnt match its digest",
+		fmt.Sprintf("keyvault+file+oci://%s/config/values:v1?file=md5.yaml.enc", host):   "Unsupported layer digest",
+		fmt.Sprintf("keyvault+file+oci://%s/config/insecure:v1", host):                   "doesnt use https",
+	} {
+		_, err := (&remoteFileUri{uri: uri, tls: TLSFiles{CaFile: ca}}).download()
+		assert.Error(err, uri)
+		if err != nil {
+			assert.Contains(err.Error(), reason, uri)
+		}
+	}
+}
+
+func Test_parseOciReference(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]ociReference{
+		"oci://myregistry.azurecr.io/config/values":                         {"myregistry.azurecr.io", "config/values", "latest", ""},
+		"oci://myregistry.azurecr.io/config/values:v1?file=values.yaml.enc": {"myregistry.azurecr.io", "config/values", "v1", "values.yaml.enc"},
+		"oci://localhost:5000/values@sha256:1234":                           {"localhost:5000", "values", "sha256:1234", ""},
+	}
+	for uri, expected := range tests {
+		ref, err := parseOciReference(uri)
+		assert.Nil(err, uri)
+		assert.Equal(expected, ref, uri)
+	}
+
+	_, err := parseOciReference("oci://myregistry.azurecr.io/")
+	assert.Error(err, "should be error")
+}
+
+func Test_newHttpClient(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newHttpClient(TLSFiles{CaFile: "/nonexisting/ca.pem"})
+	assert.Error(err, "should be error")
+	_, err = newHttpClient(TLSFiles{CertFile: "/nonexisting/cert.pem", KeyFile: "/nonexisting/key.pem"})
+	assert.Error(err, "should be error")
+	_, err = newHttpClient(TLSFiles{})
+	assert.Nil(err, "should be nil")
+}
@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// prefix of secret references inside values files, compatible with helmfile/vals
+const secretRefPrefix = "ref+azurekeyvault://"
+
+// secretResolver - resolves secret references, every referenced secret is only retrieved once
+type secretResolver struct {
+	keyvaults map[string]keyvault.KeyvaultInterface
+	pending   []structs.ObjectRef
+	values    map[string]string
+}
+
+func newSecretResolver() *secretResolver {
+	return &secretResolver{
+		keyvaults: map[string]keyvault.KeyvaultInterface{},
+		values:    map[string]string{},
+	}
+}
+
+// add - parse the given reference (keyvault/secret[/version]) and queue it for retrieval
+func (r *secretResolver) add(ref string) (structs.ObjectRef, error) {
+	or, query, err := parseObjectUri(fmt.Sprintf("%s%s", secretRefPrefix, ref), "secrets")
+	if err != nil {
+		return structs.ObjectRef{}, err
+	}
+	if len(query) > 0 {
+		return structs.ObjectRef{}, fmt.Errorf("Unsupported query parameters in secret reference '%s'", ref)
+	}
+
+	if _, exists := r.values[or.String()]; exists {
+		return or, nil
+	}
+	for _, p := range r.pending {
+		if p == or {
+			return or, nil
+		}
+	}
+	r.pending = append(r.pending, or)
+	return or, nil
+}
+
+// resolve - retrieve all pending secrets concurrently
+func (r *secretResolver) resolve() error {
+	var secrets []structs.Secret
+	for _, p := range r.pending {
+		kv, exists := r.keyvaults[p.Keyvault]
+		if !exists {
+			var err error
+			kv, err = structs.NewKeyVault(p.Keyvault)
+			if err != nil {
+				return err
+			}
+			r.keyvaults[p.Keyvault] = kv
+		}
+		secrets = append(secrets, structs.NewSecret(kv, p.Name, p.Version))
+	}
+
+	sl := structs.SecretList{}
+	secrets, err := sl.GetAll(secrets)
+	if err != nil {
+		return err
+	}
+	for i, s := range secrets {
+		val, err := s.Decode()
+		if err != nil {
+			return fmt.Errorf("Unable to decode secret %s: %v", s.Name, err)
+		}
+		r.values[r.pending[i].String()] = val
+	}
+	r.pending = nil
+	return nil
+}
+
+// value - return the value of a resolved reference
+func (r *secretResolver) value(ref structs.ObjectRef) string {
+	return r.values[ref.String()]
+}
+
+// renderValues - render the given values template. Secrets are referenced either with the
+// template functions {{ kv "keyvault" "secret" ["version"] }} and kvRaw or with yaml scalars in the
+// form ref+azurekeyvault://keyvault/secret[/version]
+func renderValues(name string, content string) (string, error) {
+	r := newSecretResolver()
+
+	// the first pass collects all references of the kv functions, the second pass inserts the values
+	collect := func(ref string) (string, error) {
+		_, err := r.add(ref)
+		return "", err
+	}
+	insert := func(ref string) (string, error) {
+		or, err := r.add(ref)
+		return r.value(or), err
+	}
+
+	_, err := executeValuesTemplate(name, content, collect)
+	if err != nil {
+		return "", err
+	}
+	err = r.resolve()
+	if err != nil {
+		return "", err
+	}
+	rendered, err := executeValuesTemplate(name, content, insert)
+	if err != nil {
+		return "", err
+	}
+
+	return replaceSecretRefs(r, rendered)
+}
+
+// executeValuesTemplate - execute the template with the kv functions using the given lookup.
+// kv inserts the value as quoted yaml scalar, kvRaw inserts it as it is, e.g. inside of other strings
+func executeValuesTemplate(name string, content string, lookup func(ref string) (string, error)) (string, error) {
+	kvRaw := func(kv string, secret string, version ...string) (string, error) {
+		return lookup(strings.Join(append([]string{kv, secret}, version...), "/"))
+	}
+	kv := func(kv string, secret string, version ...string) (string, error) {
+		v, err := kvRaw(kv, secret, version...)
+		if err != nil {
+			return "", err
+		}
+		return quoteYamlScalar(v)
+	}
+
+	tpl, err := template.New(name).Funcs(template.FuncMap{"kv": kv, "kvRaw": kvRaw}).Parse(content)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = tpl.Execute(&buf, nil)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// quoteYamlScalar - return the value as double quoted yaml scalar on a single line
+func quoteYamlScalar(v string) (string, error) {
+	b, err := yaml.Marshal(&yaml.Node{Kind: yaml.ScalarNode, Style: yaml.DoubleQuotedStyle, Tag: "!!str", Value: v})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+// replaceSecretRefs - replace all yaml scalars referencing a secret with the secrets value.
+// content without references is returned unchanged
+func replaceSecretRefs(r *secretResolver, content string) (string, error) {
+	var docs []*yaml.Node
+	dec := yaml.NewDecoder(strings.NewReader(content))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, &doc)
+	}
+
+	var nodes []*yaml.Node
+	var refs []structs.ObjectRef
+	var walk func(n *yaml.Node) error
+	walk = func(n *yaml.Node) error {
+		if n.Kind == yaml.ScalarNode && strings.HasPrefix(n.Value, secretRefPrefix) {
+			ref, err := r.add(strings.TrimPrefix(n.Value, secretRefPrefix))
+			if err != nil {
+				return err
+			}
+			nodes = append(nodes, n)
+			refs = append(refs, ref)
+		}
+		for _, c := range n.Content {
+			err := walk(c)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, doc := range docs {
+		err := walk(doc)
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(nodes) == 0 {
+		return content, nil
+	}
+
+	err := r.resolve()
+	if err != nil {
+		return "", err
+	}
+	for i, n := range nodes {
+		n.Value = r.value(refs[i])
+		n.Tag = "!!str"
+		n.Style = 0
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	for _, doc := range docs {
+		err = enc.Encode(doc)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = enc.Close()
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renderValuesFile - read and render the given values template
+func renderValuesFile(f string) (string, error) {
+	content, err := os.ReadFile(f)
+	if err != nil {
+		return "", err
+	}
+	return renderValues(f, string(content))
+}
+
+// Render - render the given values template and print the result
+func Render(f string) error {
+	rendered, err := renderValuesFile(f)
+	if err != nil {
+		return err
+	}
+	fmt.Print(rendered)
+	return nil
+}
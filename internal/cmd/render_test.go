@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_renderValues(t *testing.T) {
+	assert := assert.New(t)
+
+	structs.NewKeyVault = newValuesMockKeyVault
+	defer func() { structs.NewKeyVault = newMockKeyVault }()
+
+	tests := map[string]string{
+		// documents without references are kept as they are
+		"# comment\nkey:   value\n": "# comment\nkey:   value\n",
+		"db:\n  user: {{ kv \"mykeyvault\" \"myapp-db-user\" }}\n  password: {{ kv \"mykeyvault\" \"myapp-db-password\" \"123456\" }}\n":                        "db:\n  user: \"user\"\n  password: \"password\"\n",
+		"url: postgres://{{ kvRaw \"mykeyvault\" \"myapp-db-user\" }}@db\n":                                                                                     "url: postgres://user@db\n",
+		"db:\n  user: ref+azurekeyvault://mykeyvault/myapp-db-user\n  password: \"ref+azurekeyvault://mykeyvault.vault.azure.net/secrets/myapp-db-password\"\n": "db:\n  user: user\n  password: password\n",
+		"token: {{ kv \"mykeyvault\" \"myapp-token\" }}\nother: ref+azurekeyvault://mykeyvault/other-token\n":                                                   "token: \"token\"\nother: other\n",
+		// references in all documents are replaced
+		"user: ref+azurekeyvault://mykeyvault/myapp-db-user\n---\ntoken: ref+azurekeyvault://mykeyvault/myapp-token\n": "user: user\n---\ntoken: token\n",
+	}
+	for tpl, expected := range tests {
+		rendered, err := renderValues("values.tpl.yaml", tpl)
+		assert.Nil(err, "should be nil")
+		assert.Equal(expected, rendered, "should be equal")
+	}
+
+	// values are quoted to keep them a single yaml string
+	quoted, err := quoteYamlScalar("a: b\n# c")
+	assert.Nil(err, "should be nil")
+	assert.Equal(`"a: b\n# c"`, quoted, "should be equal")
+
+	for _, tpl := range []string{
+		"key: {{ kv \"mykeyvault\" }}\n",
+		"key: {{ kv \"mykeyvault\" \"invalid_secret\" }}\n",
+		"key: ref+azurekeyvault://mykeyvault\n",
+		"key: ref+azurekeyvault://mykeyvault/secret?query=value\n",
+	} {
+		_, err := renderValues("values.tpl.yaml", tpl)
+		assert.Error(err, tpl)
+	}
+}
+
+func Test_secretResolver_add(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newSecretResolver()
+	for _, ref := range []string{"mykeyvault/mysecret", "mykeyvault.vault.azure.net/secrets/mysecret", "mykeyvault/mysecret/v1", "mykeyvault/mysecret"} {
+		_, err := r.add(ref)
+		assert.Nil(err, "should be nil")
+	}
+	// every secret is only retrieved once
+	assert.Len(r.pending, 2, "should be 2")
+}
+
+func Test_tplUri_download(t *testing.T) {
+	assert := assert.New(t)
+
+	structs.NewKeyVault = newValuesMockKeyVault
+	defer func() { structs.NewKeyVault = newMockKeyVault }()
+
+	f := filepath.Join(t.TempDir(), "values.tpl.yaml")
+	_ = os.WriteFile(f, []byte("token: {{ kv \"mykeyvault\" \"myapp-token\" }}\n"), 0644)
+
+	u, err := parseUri("keyvault+tpl://" + f)
+	assert.Nil(err, "should be nil")
+	assert.IsType(&tplUri{}, u)
+	rendered, err := u.download()
+	assert.Nil(err, "should be nil")
+	assert.Equal("token: \"token\"\n", rendered, "should be equal")
+}
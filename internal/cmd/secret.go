@@ -1,15 +1,18 @@
 package cmd
 
 import (
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	log "github.com/sirupsen/logrus"
 	"io/ioutil"
+	"time"
+	"unicode/utf8"
 )
 
-// GetSecret - Get secret from given keyvault
-func GetSecret(kv string, sn string, ve string) error {
+// GetSecret - Get secret from given keyvault, with decode only the decoded value is printed
+func GetSecret(kv string, sn string, ve string, decode bool) error {
 
 	// retrieve and decode base64 encoded secret
 	keyvault, err := structs.NewKeyVault(kv)
@@ -24,6 +27,15 @@ func GetSecret(kv string, sn string, ve string) error {
 		return err
 	}
 
+	if decode {
+		dec, err := sec.Decode()
+		if err != nil {
+			return err
+		}
+		fmt.Print(dec)
+		return nil
+	}
+
 	j, err := json.Marshal(sec)
 	if err != nil {
 		return err
@@ -32,25 +44,97 @@ func GetSecret(kv string, sn string, ve string) error {
 	return nil
 }
 
-// PutSecret - Encode file and put secret into keyvault
-func PutSecret(kv string, sn string, f string) error {
+// PutSecretOptions - content type, tags and attributes of the secret put into keyvault
+type PutSecretOptions struct {
+	// put a new version even if the value is unchanged
+	Force bool
+	// put the file as it is instead of base64 encoding it
+	Raw         bool
+	Tags        []string
+	ContentType string
+	Expires     string
+	NotBefore   string
+	Disabled    bool
+}
+
+// apply - set the content type, tags and attributes of the given secret
+func (o PutSecretOptions) apply(sec *structs.Secret) error {
+	var err error
+	sec.Tags, err = structs.ParseTags(o.Tags, false)
+	if err != nil {
+		return err
+	}
+	sec.ContentType = o.ContentType
+	if o.Raw {
+		if sec.ContentType == structs.ContentTypeBase64 {
+			return fmt.Errorf("Raw values cant have the content type %s", structs.ContentTypeBase64)
+		}
+		if sec.ContentType == "" {
+			sec.ContentType = structs.ContentTypePlain
+		}
+	}
+	sec.Expires, err = parseTimestamp(o.Expires)
+	if err != nil {
+		return fmt.Errorf("Invalid expiry date: %v", err)
+	}
+	sec.NotBefore, err = parseTimestamp(o.NotBefore)
+	if err != nil {
+		return fmt.Errorf("Invalid not before date: %v", err)
+	}
+	if o.Disabled {
+		enabled := false
+		sec.Enabled = &enabled
+	}
+	return nil
+}
+
+// parseTimestamp - parse a RFC3339 timestamp or a date (2006-01-02), empty strings return nil
+func parseTimestamp(ts string) (*structs.JTime, error) {
+	if ts == "" {
+		return nil, nil
+	}
+	for _, f := range []string{time.RFC3339, "2006-01-02"} {
+		t, err := time.Parse(f, ts)
+		if err == nil {
+			jt := structs.JTime(t)
+			return &jt, nil
+		}
+	}
+	return nil, fmt.Errorf("'%s' is neither a RFC3339 timestamp nor a date (YYYY-MM-DD)", ts)
+}
+
+// PutSecret - Encode file and put secret into keyvault. The file is base64 encoded unless
+// it is put raw or with a content type other than base64
+func PutSecret(kv string, sn string, f string, opts PutSecretOptions) error {
 
-	// read file and convert it to base64
 	c, err := ioutil.ReadFile(f)
 	if err != nil {
 		return err
 	}
-	e := base64.StdEncoding.EncodeToString(c)
 
 	// put secret to keyvault
-	// retrieve and decode base64 encoded secret
 	keyvault, err := structs.NewKeyVault(kv)
 	if err != nil {
 		return err
 	}
 
 	sec := structs.NewSecret(keyvault, sn, "")
-	sec.Value = e
+	err = opts.apply(&sec)
+	if err != nil {
+		return err
+	}
+	// keyvault secrets are strings, binary files need to be base64 encoded
+	raw := sec.ContentType != "" && sec.ContentType != structs.ContentTypeBase64
+	if raw && !utf8.Valid(c) {
+		return fmt.Errorf("File %s isnt valid utf-8 and cant be put without base64 encoding", f)
+	}
+	sec.Encode(string(c))
+
+	// skip the upload if the latest version already contains the same value and attributes
+	if !opts.Force && sec.Unchanged() {
+		log.Infof("Secret %s unchanged", sn)
+		return nil
+	}
 
 	sec, err = sec.Put()
 	if err != nil {
@@ -61,6 +145,61 @@ func PutSecret(kv string, sn string, f string) error {
 	return nil
 }
 
+// EditSecret - Decode the latest secret version, open it in the users editor
+// and upload a new version if the content was changed
+func EditSecret(kv string, sn string) error {
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	sec := structs.NewSecret(keyvault, sn, "")
+	sec, err = sec.Get()
+	if err != nil {
+		return err
+	}
+
+	dec, err := sec.Decode()
+	if err != nil {
+		return err
+	}
+
+	edited, changed, err := editString(sn, dec)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		log.Infof("Secret %s unchanged", sn)
+		return nil
+	}
+
+	// upload the edited value as new version, the version retrieved before
+	// editing is used to detect concurrent updates of the secret
+	// the content type, tags and attributes of the secret are kept
+	upd := structs.NewSecret(keyvault, sn, "")
+	upd.ContentType = sec.ContentType
+	if upd.ContentType == "" {
+		upd.ContentType = structs.ContentTypePlain
+	}
+	upd.Tags = sec.Tags
+	upd.Enabled = sec.Enabled
+	upd.NotBefore = sec.NotBefore
+	upd.Expires = sec.Expires
+	upd.Encode(edited)
+	upd, err = upd.PutIfLatest(sec.Version)
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(upd)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
+
 // ListSecrets - List all secrets in the keyvault
 func ListSecrets(kv string) error {
 
@@ -95,3 +234,169 @@ func BackupSecret(kv string, secret string, file string) error {
 	err = sec.Backup(file)
 	return err
 }
+
+// SecretVersions - List all versions of the secret
+func SecretVersions(kv string, sn string) error {
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	sec := structs.NewSecret(keyvault, sn, "")
+	sl := structs.SecretList{}
+	sl.Secrets, err = sec.Versions()
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(sl)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
+
+// RollbackSecret - Put the value of a former version as new version of the secret or disable a version
+func RollbackSecret(kv string, sn string, to string, disable string) error {
+
+	if (to == "") == (disable == "") {
+		return errors.New("Either a version to roll back to or a version to disable is required")
+	}
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	var sec structs.Secret
+	if to != "" {
+		sec = structs.NewSecret(keyvault, sn, "")
+		sec, err = sec.Rollback(to)
+	} else {
+		sec = structs.NewSecret(keyvault, sn, disable)
+		sec, err = sec.Disable()
+	}
+	if err != nil {
+		return err
+	}
+
+	// the value of the rolled back secret isnt printed
+	sec.Value = ""
+	j, err := json.Marshal(sec)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
+
+// DeleteSecret - Delete all versions of the secret
+func DeleteSecret(kv string, sn string, yes bool) error {
+
+	err := confirm(fmt.Sprintf("Delete secret %s from keyvault %s?", sn, kv), yes)
+	if err != nil {
+		return err
+	}
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	sec := structs.NewSecret(keyvault, sn, "")
+	d, err := sec.Delete()
+	if err != nil {
+		return err
+	}
+	return printDeleted("Secret", d)
+}
+
+// ListDeletedSecrets - List all deleted secrets of a keyvault with soft-delete enabled
+func ListDeletedSecrets(kv string) error {
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	sl := structs.SecretList{}
+	dl := structs.DeletedList{}
+	dl.Deleted, err = sl.Deleted(keyvault)
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
+
+// RecoverSecret - Recover a deleted secret
+func RecoverSecret(kv string, sn string) error {
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	sec := structs.NewSecret(keyvault, sn, "")
+	sec, err = sec.Recover()
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(sec)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
+
+// PurgeSecret - Permanently delete a deleted secret
+func PurgeSecret(kv string, sn string, yes bool) error {
+
+	err := confirm(fmt.Sprintf("Permanently delete secret %s from keyvault %s? The secret can't be recovered", sn, kv), yes)
+	if err != nil {
+		return err
+	}
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	sec := structs.NewSecret(keyvault, sn, "")
+	err = sec.Purge()
+	if err != nil {
+		return err
+	}
+	log.Infof("Secret %s purged", sn)
+	return nil
+}
+
+// RestoreSecret - Restore a secret from the given backup file
+func RestoreSecret(kv string, f string) error {
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	sec, err := structs.RestoreSecret(keyvault, f)
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(sec)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
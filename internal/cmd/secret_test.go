@@ -3,11 +3,17 @@ package cmd
 import (
 	"encoding/base64"
 	"fmt"
+	mskeyvault "github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault/keyvaulttest"
 	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_GetSecret(t *testing.T) {
@@ -22,7 +28,7 @@ func Test_GetSecret(t *testing.T) {
 	// execute command
 	structs.NewKeyVault = newMockKeyVault
 	expectedOutput := "{\"id\":\"https://mykeyvault.vault.azure.net/secrets/yarp/123456\",\"name\":\"yarp\",\"keyvault\":{\"Name\":\"mykeyvault\",\"BaseUrl\":\"https://mykeyvault.vault.azure.net\"},\"version\":\"123456\",\"value\":\"Exammple Value\"}"
-	err := GetSecret("mykeyvault", "yarp", "123456")
+	err := GetSecret("mykeyvault", "yarp", "123456", false)
 	assert.Nil(err, "should be nil")
 
 	// read in output
@@ -54,8 +60,8 @@ func Test_PutSecret(t *testing.T) {
 	// execute put command, make sure received secret corresponds
 	// with the value retrieved from the keyvault
 	structs.NewKeyVault = newMockKeyVault
-	expectedOutput := fmt.Sprintf("{\"id\":\"https://mykeyvault.vault.azure.net/secrets/yarp/123456\",\"name\":\"yarp\",\"keyvault\":{\"Name\":\"mykeyvault\",\"BaseUrl\":\"https://mykeyvault.vault.azure.net\"},\"version\":\"123456\",\"value\":\"%s\"}", valueenc)
-	err := PutSecret("mykeyvault", "yarp", tmpfile.Name())
+	expectedOutput := fmt.Sprintf("{\"id\":\"https://mykeyvault.vault.azure.net/secrets/yarp/123456\",\"name\":\"yarp\",\"keyvault\":{\"Name\":\"mykeyvault\",\"BaseUrl\":\"https://mykeyvault.vault.azure.net\"},\"version\":\"123456\",\"value\":\"%s\",\"contenttype\":\"base64\"}", valueenc)
+	err := PutSecret("mykeyvault", "yarp", tmpfile.Name(), PutSecretOptions{})
 	assert.Nil(err, "should be nil")
 
 	// read in output
@@ -122,3 +128,398 @@ func Test_BackupSecret(t *testing.T) {
 	assert.Equal("", string(out))
 	assert.Equal("yarp", string(backup))
 }
+
+// editMockKeyVault - mock keyvault which returns a base64 encoded value and
+// a new version for every get request to simulate concurrent updates
+type editMockKeyVault struct {
+	MockKeyVault
+	versions []string
+	puts     []string
+}
+
+func (m *editMockKeyVault) GetSecret(name string, version string) (mskeyvault.SecretBundle, error) {
+	v := m.versions[0]
+	if len(m.versions) > 1 {
+		m.versions = m.versions[1:]
+	}
+	id := string(structs.NewKeyvaultObjectId(m.Name, "secrets", name, v))
+	value := base64.StdEncoding.EncodeToString([]byte("key: value\n"))
+	ct := structs.ContentTypeBase64
+	return mskeyvault.SecretBundle{
+		ID:          &id,
+		Value:       &value,
+		ContentType: &ct,
+	}, nil
+}
+
+func (m *editMockKeyVault) SetSecret(name string, parameters mskeyvault.SecretSetParameters) (mskeyvault.SecretBundle, error) {
+	m.puts = append(m.puts, *parameters.Value)
+	return m.MockKeyVault.SetSecret(name, parameters)
+}
+
+func Test_EditSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := &editMockKeyVault{versions: []string{"v1"}}
+	mock.SetKeyvaultName("mykeyvault")
+	structs.NewKeyVault = func(name string) (keyvault.KeyvaultInterface, error) { return mock, nil }
+	editor, _ := writeEditor(t, "echo 'other: value' >> \"$1\"")
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", editor)
+
+	oldStdout := os.Stdout
+	_, w, _ := os.Pipe()
+	os.Stdout = w
+	err := EditSecret("mykeyvault", "yarp")
+	w.Close()
+	os.Stdout = oldStdout
+
+	assert.Nil(err, "should be nil")
+	assert.Len(mock.puts, 1, "should be 1")
+	dec, _ := base64.StdEncoding.DecodeString(mock.puts[0])
+	assert.Equal("key: value\nother: value\n", string(dec))
+}
+
+func Test_EditSecret_Unchanged(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := &editMockKeyVault{versions: []string{"v1"}}
+	mock.SetKeyvaultName("mykeyvault")
+	structs.NewKeyVault = func(name string) (keyvault.KeyvaultInterface, error) { return mock, nil }
+	editor, _ := writeEditor(t, "true")
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", editor)
+
+	err := EditSecret("mykeyvault", "yarp")
+	assert.Nil(err, "should be nil")
+	assert.Empty(mock.puts, "should be empty")
+}
+
+func Test_EditSecret_ConcurrentUpdate(t *testing.T) {
+	assert := assert.New(t)
+
+	// the secret gets a new version while it is edited
+	mock := &editMockKeyVault{versions: []string{"v1", "v2"}}
+	mock.SetKeyvaultName("mykeyvault")
+	structs.NewKeyVault = func(name string) (keyvault.KeyvaultInterface, error) { return mock, nil }
+	editor, _ := writeEditor(t, "echo 'other: value' >> \"$1\"")
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", editor)
+
+	err := EditSecret("mykeyvault", "yarp")
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), "updated concurrently")
+	assert.Empty(mock.puts, "should be empty")
+}
+
+func Test_EditSecret_Attributes(t *testing.T) {
+	assert := assert.New(t)
+
+	store := keyvaulttest.NewStore()
+	structs.NewKeyVault = newStoreMockKeyVault(store)
+	defer func() { structs.NewKeyVault = newMockKeyVault }()
+	editor, _ := writeEditor(t, "echo 'other: value' >> \"$1\"")
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", editor)
+
+	f := filepath.Join(t.TempDir(), "values.yaml")
+	_ = os.WriteFile(f, []byte("key: value\n"), 0644)
+
+	oldStdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer func() {
+		os.Stdout.Close()
+		os.Stdout = oldStdout
+	}()
+
+	_ = PutSecret("mykeyvault", "values", f, PutSecretOptions{Expires: "2030-01-01", NotBefore: "2020-01-01"})
+	err := EditSecret("mykeyvault", "values")
+	assert.Nil(err, "should be nil")
+
+	// the new version keeps the expiry and not-before date
+	assert.Len(store.Secrets["values"], 2, "should create a new version")
+	sb := store.Secrets["values"][1]
+	assert.Equal("2030-01-01T00:00:00Z", time.Time(*sb.Attributes.Expires).UTC().Format(time.RFC3339))
+	assert.Equal("2020-01-01T00:00:00Z", time.Time(*sb.Attributes.NotBefore).UTC().Format(time.RFC3339))
+}
+
+func Test_PutSecret_Chunked(t *testing.T) {
+	assert := assert.New(t)
+
+	store := keyvaulttest.NewStore()
+	structs.NewKeyVault = newStoreMockKeyVault(store)
+	defer func() { structs.NewKeyVault = newMockKeyVault }()
+
+	// values exceeding the keyvault size limit are stored in multiple secrets
+	content := strings.Repeat("key: value\n", 5000)
+	f := filepath.Join(t.TempDir(), "values.yaml")
+	_ = os.WriteFile(f, []byte(content), 0644)
+
+	// the output contains the whole value, discard it
+	oldStdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	err := PutSecret("mykeyvault", "values", f, PutSecretOptions{})
+	os.Stdout.Close()
+	os.Stdout = oldStdout
+	assert.Nil(err, "should be nil")
+	assert.Greater(len(store.Secrets), 1, "should be split into multiple secrets")
+
+	// the downloader reassembles the value
+	u, _ := parseUri("keyvault+secret://mykeyvault/values")
+	value, err := u.download()
+	assert.Nil(err, "should be nil")
+	assert.Equal(content, value, "should be equal")
+}
+
+func Test_PutSecret_Unchanged(t *testing.T) {
+	assert := assert.New(t)
+
+	store := keyvaulttest.NewStore()
+	structs.NewKeyVault = newStoreMockKeyVault(store)
+	defer func() { structs.NewKeyVault = newMockKeyVault }()
+
+	f := filepath.Join(t.TempDir(), "values.yaml")
+	_ = os.WriteFile(f, []byte("key: value\n"), 0644)
+
+	oldStdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer func() {
+		os.Stdout.Close()
+		os.Stdout = oldStdout
+	}()
+
+	// the second put of the same content is skipped
+	err := PutSecret("mykeyvault", "values", f, PutSecretOptions{})
+	assert.Nil(err, "should be nil")
+	err = PutSecret("mykeyvault", "values", f, PutSecretOptions{})
+	assert.Nil(err, "should be nil")
+	assert.Len(store.Secrets["values"], 1, "should only create one version")
+
+	// force creates a new version
+	err = PutSecret("mykeyvault", "values", f, PutSecretOptions{Force: true})
+	assert.Nil(err, "should be nil")
+	assert.Len(store.Secrets["values"], 2, "should create a new version")
+
+	// changed content creates a new version
+	_ = os.WriteFile(f, []byte("key: other\n"), 0644)
+	err = PutSecret("mykeyvault", "values", f, PutSecretOptions{})
+	assert.Nil(err, "should be nil")
+	assert.Len(store.Secrets["values"], 3, "should create a new version")
+}
+
+func Test_PutSecret_Options(t *testing.T) {
+	assert := assert.New(t)
+
+	store := keyvaulttest.NewStore()
+	structs.NewKeyVault = newStoreMockKeyVault(store)
+	defer func() { structs.NewKeyVault = newMockKeyVault }()
+
+	f := filepath.Join(t.TempDir(), "values.yaml")
+	_ = os.WriteFile(f, []byte("key: value\n"), 0644)
+
+	oldStdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer func() {
+		os.Stdout.Close()
+		os.Stdout = oldStdout
+	}()
+
+	err := PutSecret("mykeyvault", "values", f, PutSecretOptions{
+		Tags:        []string{"owner=team-a", "app=myapp"},
+		ContentType: "base64",
+		Expires:     "2030-01-01",
+		NotBefore:   "2020-01-01T12:00:00+01:00",
+		Disabled:    true,
+	})
+	assert.Nil(err, "should be nil")
+	sb := store.Secrets["values"][0]
+	assert.Equal("team-a", *sb.Tags["owner"], "should be equal")
+	assert.Equal("myapp", *sb.Tags["app"], "should be equal")
+	assert.Equal("base64", *sb.ContentType, "should be equal")
+	assert.False(*sb.Attributes.Enabled, "should be disabled")
+	assert.Equal("2030-01-01T00:00:00Z", time.Time(*sb.Attributes.Expires).UTC().Format(time.RFC3339))
+	assert.Equal("2020-01-01T11:00:00Z", time.Time(*sb.Attributes.NotBefore).UTC().Format(time.RFC3339))
+
+	err = PutSecret("mykeyvault", "values", f, PutSecretOptions{Tags: []string{"owner"}})
+	assert.Error(err, "should be error")
+	err = PutSecret("mykeyvault", "values", f, PutSecretOptions{Expires: "next year"})
+	assert.Error(err, "should be error")
+	assert.Len(store.Secrets["values"], 1, "should not create a new version")
+}
+
+func Test_PutSecret_Raw(t *testing.T) {
+	assert := assert.New(t)
+
+	store := keyvaulttest.NewStore()
+	structs.NewKeyVault = newStoreMockKeyVault(store)
+	defer func() { structs.NewKeyVault = newMockKeyVault }()
+
+	f := filepath.Join(t.TempDir(), "password")
+	_ = os.WriteFile(f, []byte("s3cr3t"), 0644)
+
+	oldStdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	err := PutSecret("mykeyvault", "password", f, PutSecretOptions{Raw: true})
+	os.Stdout.Close()
+	os.Stdout = oldStdout
+	assert.Nil(err, "should be nil")
+	sb := store.Secrets["password"][0]
+	assert.Equal("s3cr3t", *sb.Value, "should be equal")
+	assert.Equal(structs.ContentTypePlain, *sb.ContentType, "should be equal")
+
+	// raw values cant be base64 and need to be valid utf-8
+	err = PutSecret("mykeyvault", "password", f, PutSecretOptions{Raw: true, ContentType: "base64"})
+	assert.Error(err, "should be error")
+	_ = os.WriteFile(f, []byte{0xff, 0xfe}, 0644)
+	err = PutSecret("mykeyvault", "password", f, PutSecretOptions{Raw: true})
+	assert.Error(err, "should be error")
+
+	// get prints the decoded value
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	err = GetSecret("mykeyvault", "password", "", true)
+	w.Close()
+	out, _ := ioutil.ReadAll(r)
+	os.Stdout = oldStdout
+	assert.Nil(err, "should be nil")
+	assert.Equal("s3cr3t", string(out), "should be equal")
+
+	// the downloader returns raw values as they are
+	u, _ := parseUri("keyvault+secret://mykeyvault/password")
+	value, err := u.download()
+	assert.Nil(err, "should be nil")
+	assert.Equal("s3cr3t", value, "should be equal")
+}
+
+func Test_RollbackSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	store := keyvaulttest.NewStore()
+	structs.NewKeyVault = newStoreMockKeyVault(store)
+	defer func() { structs.NewKeyVault = newMockKeyVault }()
+
+	oldStdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer func() {
+		os.Stdout.Close()
+		os.Stdout = oldStdout
+	}()
+
+	f := filepath.Join(t.TempDir(), "values.yaml")
+	for _, content := range []string{"key: first\n", "key: second\n"} {
+		_ = os.WriteFile(f, []byte(content), 0644)
+		err := PutSecret("mykeyvault", "values", f, PutSecretOptions{})
+		assert.Nil(err, "should be nil")
+	}
+
+	err := SecretVersions("mykeyvault", "values")
+	assert.Nil(err, "should be nil")
+	err = SecretVersions("mykeyvault", "missing")
+	assert.Error(err, "should be error")
+
+	// either a version to roll back to or to disable is required
+	err = RollbackSecret("mykeyvault", "values", "", "")
+	assert.Error(err, "should be error")
+	err = RollbackSecret("mykeyvault", "values", "version1", "version2")
+	assert.Error(err, "should be error")
+
+	err = RollbackSecret("mykeyvault", "values", "version1", "")
+	assert.Nil(err, "should be nil")
+	assert.Len(store.Secrets["values"], 3, "should create a new version")
+	u, _ := parseUri("keyvault+secret://mykeyvault/values")
+	value, _ := u.download()
+	assert.Equal("key: first\n", value, "should be equal")
+
+	err = RollbackSecret("mykeyvault", "values", "", "version2")
+	assert.Nil(err, "should be nil")
+	assert.False(*store.Secrets["values"][1].Attributes.Enabled, "should be disabled")
+}
+
+func Test_DeleteSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	store := keyvaulttest.NewStore()
+	structs.NewKeyVault = newStoreMockKeyVault(store)
+	defer func() {
+		structs.NewKeyVault = newMockKeyVault
+		confirmInput = os.Stdin
+	}()
+
+	oldStdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer func() {
+		os.Stdout.Close()
+		os.Stdout = oldStdout
+	}()
+
+	f := filepath.Join(t.TempDir(), "values.yaml")
+	_ = os.WriteFile(f, []byte("key: value\n"), 0644)
+	_ = PutSecret("mykeyvault", "values", f, PutSecretOptions{})
+
+	// the deletion requires a confirmation
+	confirmInput = strings.NewReader("n\n")
+	err := DeleteSecret("mykeyvault", "values", false)
+	assert.Error(err, "should be error")
+	assert.Len(store.Secrets, 1, "should not be deleted")
+	confirmInput = strings.NewReader("")
+	err = DeleteSecret("mykeyvault", "values", false)
+	assert.Error(err, "should be error")
+
+	confirmInput = strings.NewReader("yes\n")
+	err = DeleteSecret("mykeyvault", "values", false)
+	assert.Nil(err, "should be nil")
+	assert.Empty(store.Secrets, "should be deleted")
+
+	err = ListDeletedSecrets("mykeyvault")
+	assert.Nil(err, "should be nil")
+
+	err = RecoverSecret("mykeyvault", "values")
+	assert.Nil(err, "should be nil")
+	assert.Len(store.Secrets, 1, "should be recovered")
+
+	err = DeleteSecret("mykeyvault", "values", true)
+	assert.Nil(err, "should be nil")
+	err = PurgeSecret("mykeyvault", "values", true)
+	assert.Nil(err, "should be nil")
+	assert.Empty(store.Deleted, "should be purged")
+	err = RecoverSecret("mykeyvault", "values")
+	assert.Error(err, "should be error")
+}
+
+func Test_RestoreSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	store := keyvaulttest.NewStore()
+	structs.NewKeyVault = newStoreMockKeyVault(store)
+	defer func() { structs.NewKeyVault = newMockKeyVault }()
+
+	oldStdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer func() {
+		os.Stdout.Close()
+		os.Stdout = oldStdout
+	}()
+
+	f := filepath.Join(t.TempDir(), "values.yaml")
+	_ = os.WriteFile(f, []byte("key: value\n"), 0644)
+	_ = PutSecret("mykeyvault", "values", f, PutSecretOptions{})
+	backup := filepath.Join(t.TempDir(), "VALUES.pem")
+	err := BackupSecret("mykeyvault", "values", backup)
+	assert.Nil(err, "should be nil")
+
+	// the secret exists in the keyvault
+	err = RestoreSecret("mykeyvault", backup)
+	assert.Error(err, "should be error")
+
+	err = DeleteSecret("mykeyvault", "values", true)
+	assert.Nil(err, "should be nil")
+	err = PurgeSecret("mykeyvault", "values", true)
+	assert.Nil(err, "should be nil")
+	err = RestoreSecret("mykeyvault", backup)
+	assert.Nil(err, "should be nil")
+
+	u, _ := parseUri("keyvault+secret://mykeyvault/values")
+	value, err := u.download()
+	assert.Nil(err, "should be nil")
+	assert.Equal("key: value\n", value, "should be equal")
+}
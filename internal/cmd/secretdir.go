@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"os"
+)
+
+// SecretDirectoryOptions - options of the secrets plan and apply commands
+type SecretDirectoryOptions struct {
+	// prefix added to the secret names derived from the file paths
+	Prefix string
+	// delete the secrets with the prefix which dont exist as file
+	Prune bool
+	// plan reviewed before the apply, the apply fails if the changes differ
+	Plan string
+	Yes  bool
+}
+
+// PlanSecrets - Print the changes required to bring the secrets of the keyvault in line with the files of the directory
+func PlanSecrets(kv string, dir string, opts SecretDirectoryOptions) error {
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	changes, err := structs.PlanSecretDirectory(keyvault, dir, opts.Prefix, opts.Prune)
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
+
+// ApplySecrets - Put the new and changed files of the directory as secrets into the keyvault,
+// with prune the secrets which dont exist as file are deleted
+func ApplySecrets(kv string, dir string, opts SecretDirectoryOptions) error {
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	changes, err := structs.PlanSecretDirectory(keyvault, dir, opts.Prefix, opts.Prune)
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	if opts.Plan != "" {
+		c, err := os.ReadFile(opts.Plan)
+		if err != nil {
+			return err
+		}
+		var planned []structs.SecretChange
+		err = json.Unmarshal(c, &planned)
+		if err != nil {
+			return fmt.Errorf("Invalid plan %s: %v", opts.Plan, err)
+		}
+		// only the exported fields of the changes are part of the plan
+		p, err := json.Marshal(planned)
+		if err != nil {
+			return err
+		}
+		if string(p) != string(j) {
+			return fmt.Errorf("The secrets of keyvault %s or the files of %s changed since plan %s was created", kv, dir, opts.Plan)
+		}
+	}
+	fmt.Print(string(j))
+
+	return applySecretChanges(kv, changes, opts.Yes)
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault/keyvaulttest"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_PlanSecrets(t *testing.T) {
+	assert := assert.New(t)
+
+	store := keyvaulttest.NewStore()
+	structs.NewKeyVault = newStoreMockKeyVault(store)
+	defer func() { structs.NewKeyVault = newMockKeyVault }()
+
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "values.yaml"), []byte("key: value\n"), 0644)
+
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	err := PlanSecrets("mykeyvault", dir, SecretDirectoryOptions{Prefix: "app-"})
+	w.Close()
+	os.Stdout = oldStdout
+	out, _ := ioutil.ReadAll(r)
+
+	assert.Nil(err, "should be nil")
+	assert.Equal(`[{"action":"create","name":"app-values","source":"values.yaml"}]`, string(out), "should be equal")
+	assert.Len(store.Secrets, 0, "should not be written")
+}
+
+func Test_ApplySecrets(t *testing.T) {
+	assert := assert.New(t)
+
+	store := keyvaulttest.NewStore()
+	structs.NewKeyVault = newStoreMockKeyVault(store)
+	defer func() {
+		structs.NewKeyVault = newMockKeyVault
+		confirmInput = os.Stdin
+	}()
+
+	oldStdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer func() {
+		os.Stdout.Close()
+		os.Stdout = oldStdout
+	}()
+
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "values.yaml"), []byte("key: value\n"), 0644)
+	f := filepath.Join(t.TempDir(), "obsolete.yaml")
+	_ = os.WriteFile(f, []byte("obsolete"), 0644)
+	_ = PutSecret("mykeyvault", "app-obsolete", f, PutSecretOptions{})
+
+	err := ApplySecrets("mykeyvault", dir, SecretDirectoryOptions{Prefix: "app-"})
+	assert.Nil(err, "should be nil")
+	assert.Len(store.Secrets["app-values"], 1, "should be created")
+
+	// unchanged files arent written again
+	err = ApplySecrets("mykeyvault", dir, SecretDirectoryOptions{Prefix: "app-"})
+	assert.Nil(err, "should be nil")
+	assert.Len(store.Secrets["app-values"], 1, "should be unchanged")
+
+	// the reviewed plan needs to match the changes
+	plan := filepath.Join(t.TempDir(), "plan.json")
+	_ = os.WriteFile(plan, []byte(`[{"action":"unchanged","name":"app-values","source":"values.yaml"}]`), 0644)
+	opts := SecretDirectoryOptions{Prefix: "app-", Prune: true, Plan: plan}
+	err = ApplySecrets("mykeyvault", dir, opts)
+	assert.Error(err, "should be error")
+	assert.Len(store.Secrets["app-obsolete"], 1, "should not be deleted")
+
+	_ = os.WriteFile(plan, []byte(`[{"action":"unchanged","name":"app-values","source":"values.yaml"},{"action":"delete","name":"app-obsolete"}]`), 0644)
+	confirmInput = strings.NewReader("n\n")
+	err = ApplySecrets("mykeyvault", dir, opts)
+	assert.Error(err, "should be error")
+	assert.Len(store.Secrets["app-obsolete"], 1, "should not be deleted")
+	opts.Yes = true
+	err = ApplySecrets("mykeyvault", dir, opts)
+	assert.Nil(err, "should be nil")
+	assert.Len(store.Secrets["app-obsolete"], 0, "should be deleted")
+
+	_ = os.WriteFile(plan, []byte("invalid"), 0644)
+	err = ApplySecrets("mykeyvault", dir, opts)
+	assert.Error(err, "should be error")
+}
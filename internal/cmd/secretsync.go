@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	log "github.com/sirupsen/logrus"
+)
+
+// SyncSecretsOptions - options of the secrets copy and sync commands
+type SyncSecretsOptions struct {
+	Prefix string
+	Tags   []string
+	// rename in the form pattern=replacement
+	Rename string
+	// delete the secrets of the target keyvault which dont exist in the source keyvault
+	Prune  bool
+	DryRun bool
+	Yes    bool
+}
+
+// SyncSecrets - Copy the selected secrets from one keyvault into another, only changed secrets are written.
+// With prune the selected secrets missing in the source keyvault are deleted from the target keyvault
+func SyncSecrets(from string, to string, opts SyncSecretsOptions) error {
+
+	tags, err := structs.ParseTags(opts.Tags, true)
+	if err != nil {
+		return err
+	}
+	rename, err := structs.ParseSecretRename(opts.Rename)
+	if err != nil {
+		return err
+	}
+
+	source, err := structs.NewKeyVault(from)
+	if err != nil {
+		return err
+	}
+	target, err := structs.NewKeyVault(to)
+	if err != nil {
+		return err
+	}
+
+	changes, err := structs.PlanSecretSync(source, target, structs.SecretSyncOptions{
+		Filter: structs.SecretFilter{Prefix: opts.Prefix, Tags: tags},
+		Rename: rename,
+		Prune:  opts.Prune,
+	})
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+
+	if opts.DryRun {
+		return nil
+	}
+	return applySecretChanges(to, changes, opts.Yes)
+}
+
+// applySecretChanges - apply the changes to the keyvault, deletions require a confirmation
+func applySecretChanges(kv string, changes []structs.SecretChange, yes bool) error {
+
+	count := map[string]int{}
+	for _, c := range changes {
+		count[c.Action]++
+	}
+	if count[structs.SecretChangeDelete] > 0 {
+		err := confirm(fmt.Sprintf("Delete %d secrets from keyvault %s?", count[structs.SecretChangeDelete], kv), yes)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := structs.ApplySecretChanges(changes)
+	if err != nil {
+		return err
+	}
+	log.Infof("Keyvault %s: %d secrets created, %d updated, %d deleted, %d unchanged", kv,
+		count[structs.SecretChangeCreate], count[structs.SecretChangeUpdate], count[structs.SecretChangeDelete], count[structs.SecretChangeUnchanged])
+	return nil
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault/keyvaulttest"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_SyncSecrets(t *testing.T) {
+	assert := assert.New(t)
+
+	// every keyvault has its own secrets
+	stores := map[string]*keyvaulttest.Store{"staging": keyvaulttest.NewStore(), "prod": keyvaulttest.NewStore()}
+	structs.NewKeyVault = func(name string) (keyvault.KeyvaultInterface, error) {
+		return newStoreMockKeyVault(stores[name])(name)
+	}
+	defer func() {
+		structs.NewKeyVault = newMockKeyVault
+		confirmInput = os.Stdin
+	}()
+
+	oldStdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer func() {
+		os.Stdout.Close()
+		os.Stdout = oldStdout
+	}()
+
+	f := filepath.Join(t.TempDir(), "values.yaml")
+	_ = os.WriteFile(f, []byte("key: value\n"), 0644)
+	_ = PutSecret("staging", "staging-values", f, PutSecretOptions{Tags: []string{"env=staging"}})
+	_ = PutSecret("staging", "staging-other", f, PutSecretOptions{})
+	_ = PutSecret("prod", "prod-obsolete", f, PutSecretOptions{Tags: []string{"env=staging"}})
+
+	// nothing is written with dry-run
+	opts := SyncSecretsOptions{Tags: []string{"env"}, Rename: "^staging-=prod-", DryRun: true}
+	err := SyncSecrets("staging", "prod", opts)
+	assert.Nil(err, "should be nil")
+	assert.Len(stores["prod"].Secrets, 1, "should be unchanged")
+
+	opts.DryRun = false
+	err = SyncSecrets("staging", "prod", opts)
+	assert.Nil(err, "should be nil")
+	assert.Len(stores["prod"].Secrets["prod-values"], 1, "should be copied")
+	assert.Len(stores["prod"].Secrets["prod-other"], 0, "should not be copied")
+	assert.Equal("staging", *stores["prod"].Secrets["prod-values"][0].Tags["env"], "should keep the tags")
+
+	// unchanged secrets arent written again
+	err = SyncSecrets("staging", "prod", opts)
+	assert.Nil(err, "should be nil")
+	assert.Len(stores["prod"].Secrets["prod-values"], 1, "should be unchanged")
+
+	// deletions require a confirmation
+	opts.Prune = true
+	confirmInput = strings.NewReader("n\n")
+	err = SyncSecrets("staging", "prod", opts)
+	assert.Error(err, "should be error")
+	assert.Len(stores["prod"].Secrets["prod-obsolete"], 1, "should not be deleted")
+	opts.Yes = true
+	err = SyncSecrets("staging", "prod", opts)
+	assert.Nil(err, "should be nil")
+	assert.Len(stores["prod"].Secrets["prod-obsolete"], 0, "should be deleted")
+
+	err = SyncSecrets("staging", "prod", SyncSecretsOptions{Rename: "staging-"})
+	assert.Error(err, "should be error")
+	err = SyncSecrets("staging", "prod", SyncSecretsOptions{Tags: []string{"=staging"}})
+	assert.Error(err, "should be error")
+}
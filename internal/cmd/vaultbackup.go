@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	log "github.com/sirupsen/logrus"
+)
+
+// BackupVault - Write the backups of all secrets and keys matching the prefix and tags into a single archive
+func BackupVault(kv string, archive string, prefix string, tags []string, types []string) error {
+
+	filter := structs.SecretFilter{Prefix: prefix}
+	var err error
+	filter.Tags, err = structs.ParseTags(tags, true)
+	if err != nil {
+		return err
+	}
+	if len(types) == 0 {
+		types = []string{"secrets", "keys"}
+	}
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	vb, err := structs.BackupVault(keyvault, filter, types, archive)
+	if err != nil {
+		return err
+	}
+	log.Infof("%d objects of keyvault %s written to %s", len(vb.Objects), kv, archive)
+
+	j, err := json.Marshal(vb)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+	return nil
+}
+
+// RestoreVault - Restore the secrets and keys of a backup archive into the keyvault, with overwrite existing
+// objects are deleted and purged before they are restored
+func RestoreVault(kv string, archive string, overwrite bool, yes bool) error {
+
+	if overwrite {
+		err := confirm(fmt.Sprintf("Irreversibly delete and purge the existing secrets and keys of keyvault %s to restore the objects of %s?", kv, archive), yes)
+		if err != nil {
+			return err
+		}
+	}
+
+	keyvault, err := structs.NewKeyVault(kv)
+	if err != nil {
+		return err
+	}
+
+	results, err := structs.RestoreVault(keyvault, archive, overwrite)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range results {
+		switch r.Status {
+		case structs.RestoreStatusFailed:
+			failed++
+			log.Warnf("%s %s failed: %s", r.Type, r.Name, r.Error)
+		case structs.RestoreStatusAborted:
+			failed++
+			log.Warnf("%s %s not restored, the restore was aborted", r.Type, r.Name)
+		case structs.RestoreStatusSkipped:
+			log.Infof("%s %s skipped, it already exists", r.Type, r.Name)
+		default:
+			log.Infof("%s %s %s", r.Type, r.Name, r.Status)
+		}
+	}
+
+	j, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(j))
+
+	if failed > 0 {
+		return fmt.Errorf("Unable to restore %d of %d objects into keyvault %s", failed, len(results), kv)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault/keyvaulttest"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/structs"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_BackupVault(t *testing.T) {
+	assert := assert.New(t)
+
+	source := keyvaulttest.NewStore()
+	structs.NewKeyVault = newStoreMockKeyVault(source)
+	defer func() {
+		structs.NewKeyVault = newMockKeyVault
+		confirmInput = os.Stdin
+	}()
+
+	oldStdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer func() {
+		os.Stdout.Close()
+		os.Stdout = oldStdout
+	}()
+
+	f := filepath.Join(t.TempDir(), "values.yaml")
+	_ = os.WriteFile(f, []byte("key: value\n"), 0644)
+	_ = PutSecret("source", "app-values", f, PutSecretOptions{Tags: []string{"env=prod"}})
+	_ = PutSecret("source", "app-other", f, PutSecretOptions{})
+
+	archive := filepath.Join(t.TempDir(), "vault.tar.zst")
+	err := BackupVault("source", archive, "app-", []string{"env=prod"}, []string{"secrets"})
+	assert.Nil(err, "should be nil")
+	err = BackupVault("source", archive, "", []string{"env=prod"}, []string{"certificates"})
+	assert.Error(err, "should be error")
+	err = BackupVault("source", archive, "", []string{"=prod"}, []string{"secrets"})
+	assert.Error(err, "should be error")
+
+	// restore into an empty keyvault
+	target := keyvaulttest.NewStore()
+	structs.NewKeyVault = newStoreMockKeyVault(target)
+	err = RestoreVault("target", archive, false, false)
+	assert.Nil(err, "should be nil")
+	assert.Len(target.Secrets, 1, "should contain the filtered secret")
+	assert.Len(target.Secrets["app-values"], 1, "should be restored")
+
+	// the overwrite requires a confirmation
+	confirmInput = strings.NewReader("n\n")
+	err = RestoreVault("target", archive, true, false)
+	assert.Error(err, "should be error")
+	err = RestoreVault("target", archive, true, true)
+	assert.Nil(err, "should be nil")
+	assert.Len(target.Secrets["app-values"], 1, "should be overwritten")
+
+	err = RestoreVault("target", filepath.Join(t.TempDir(), "missing.tar.zst"), false, false)
+	assert.Error(err, "should be error")
+}
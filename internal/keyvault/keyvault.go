@@ -5,8 +5,8 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"github.com/Azure/azure-sdk-for-go/profiles/latest/keyvault/keyvault"
 	kvauth "github.com/Azure/azure-sdk-for-go/services/keyvault/auth"
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure"
 	log "github.com/sirupsen/logrus"
@@ -27,16 +27,33 @@ type KeyvaultInterface interface {
 	SetKeyvaultName(name string)
 	// secrets operations
 	GetSecret(sn string, sv string) (keyvault.SecretBundle, error)
-	PutSecret(name string, value string) (keyvault.SecretBundle, error)
+	SetSecret(name string, parameters keyvault.SecretSetParameters) (keyvault.SecretBundle, error)
 	ListSecrets() ([]keyvault.SecretBundle, error)
+	ListSecretVersions(name string) ([]keyvault.SecretBundle, error)
+	UpdateSecret(name string, version string, parameters keyvault.SecretUpdateParameters) (keyvault.SecretBundle, error)
 	BackupSecret(sn string) (string, error)
+	RestoreSecret(file string) (keyvault.SecretBundle, error)
+	DeleteSecret(name string) (keyvault.DeletedSecretBundle, error)
+	ListDeletedSecrets() ([]keyvault.DeletedSecretBundle, error)
+	RecoverDeletedSecret(name string) (keyvault.SecretBundle, error)
+	PurgeDeletedSecret(name string) error
 	// keys operations
 	EncryptString(key string, version string, encoded string) (keyvault.KeyOperationResult, error)
 	DecryptString(key string, version string, encrypted string) (keyvault.KeyOperationResult, error)
 	ListKeys() ([]keyvault.KeyBundle, error)
 	BackupKey(key string) (string, error)
+	RestoreKey(file string) (keyvault.KeyBundle, error)
 	CreateKey(key string) (keyvault.KeyBundle, error)
 	GetKey(key string, version string) (keyvault.KeyBundle, error)
+	DeleteKey(key string) (keyvault.DeletedKeyBundle, error)
+	ListDeletedKeys() ([]keyvault.DeletedKeyBundle, error)
+	RecoverDeletedKey(key string) (keyvault.KeyBundle, error)
+	PurgeDeletedKey(key string) error
+	// certificates operations
+	GetCertificate(name string, version string) (keyvault.CertificateBundle, error)
+	ListCertificates() ([]keyvault.CertificateBundle, error)
+	BackupCertificate(name string) (string, error)
+	RestoreCertificate(file string) (keyvault.CertificateBundle, error)
 }
 
 type Keyvault struct {
@@ -92,16 +109,9 @@ func (k *Keyvault) GetSecret(sn string, sv string) (keyvault.SecretBundle, error
 	return s, nil
 }
 
-// PutSecret - put secret into keyvault
-func (k *Keyvault) PutSecret(name string, value string) (keyvault.SecretBundle, error) {
-
-	ct := "base64"
-	sp := keyvault.SecretSetParameters{
-		Value:       &value,
-		ContentType: &ct,
-	}
-
-	s, err := k.Client.SetSecret(context.Background(), k.BaseUrl, name, sp)
+// SetSecret - put a new secret version with the given value, content type, tags and attributes
+func (k *Keyvault) SetSecret(name string, parameters keyvault.SecretSetParameters) (keyvault.SecretBundle, error) {
+	s, err := k.Client.SetSecret(context.Background(), k.BaseUrl, name, parameters)
 	if err != nil {
 		return keyvault.SecretBundle{}, err
 	}
@@ -143,13 +153,14 @@ func (k *Keyvault) RestoreSecret(file string) (keyvault.SecretBundle, error) {
 	return s, nil
 }
 
-// ListSecrets - list all secrets in the specified keyvault
+// ListSecrets - list all secrets in the specified keyvault. The returned secrets contain
+// the secrets metadata (id, attributes, tags and content type) but no values
 func (k *Keyvault) ListSecrets() ([]keyvault.SecretBundle, error) {
 
 	ctx := context.Background()
 	siter, err := k.Client.GetSecretsComplete(ctx, k.BaseUrl, nil)
 	if err != nil {
-		log.Fatalf("unable to get list of secrets: %v\n", err)
+		return []keyvault.SecretBundle{}, fmt.Errorf("unable to get list of secrets: %v", err)
 	}
 
 	var s []keyvault.SecretBundle
@@ -157,13 +168,43 @@ func (k *Keyvault) ListSecrets() ([]keyvault.SecretBundle, error) {
 	for siter.NotDone() {
 		i := siter.Value()
 
-		key := path.Base(*i.ID)
-		b, err := k.Client.GetSecret(context.Background(), k.BaseUrl, key, "")
+		s = append(s, keyvault.SecretBundle{
+			ID:          i.ID,
+			Attributes:  i.Attributes,
+			Tags:        i.Tags,
+			ContentType: i.ContentType,
+			Managed:     i.Managed,
+		})
+		err = siter.NextWithContext(ctx)
 		if err != nil {
 			return []keyvault.SecretBundle{}, err
 		}
+	}
+
+	return s, nil
+}
+
+// ListSecretVersions - list all versions of the given secret. The returned versions contain
+// the metadata (id, attributes, tags and content type) but no values
+func (k *Keyvault) ListSecretVersions(name string) ([]keyvault.SecretBundle, error) {
+
+	ctx := context.Background()
+	siter, err := k.Client.GetSecretVersionsComplete(ctx, k.BaseUrl, name, nil)
+	if err != nil {
+		return []keyvault.SecretBundle{}, fmt.Errorf("unable to get versions of secret %s: %v", name, err)
+	}
+
+	var s []keyvault.SecretBundle
+	for siter.NotDone() {
+		i := siter.Value()
 
-		s = append(s, b)
+		s = append(s, keyvault.SecretBundle{
+			ID:          i.ID,
+			Attributes:  i.Attributes,
+			Tags:        i.Tags,
+			ContentType: i.ContentType,
+			Managed:     i.Managed,
+		})
 		err = siter.NextWithContext(ctx)
 		if err != nil {
 			return []keyvault.SecretBundle{}, err
@@ -173,6 +214,72 @@ func (k *Keyvault) ListSecrets() ([]keyvault.SecretBundle, error) {
 	return s, nil
 }
 
+// UpdateSecret - update the attributes, tags or content type of the given secret version
+func (k *Keyvault) UpdateSecret(name string, version string, parameters keyvault.SecretUpdateParameters) (keyvault.SecretBundle, error) {
+	s, err := k.Client.UpdateSecret(context.Background(), k.BaseUrl, name, version, parameters)
+	if err != nil {
+		return keyvault.SecretBundle{}, err
+	}
+	return s, nil
+}
+
+// DeleteSecret - delete all versions of the secret. In keyvaults with soft-delete enabled
+// the secret can be recovered until the scheduled purge date
+func (k *Keyvault) DeleteSecret(name string) (keyvault.DeletedSecretBundle, error) {
+	d, err := k.Client.DeleteSecret(context.Background(), k.BaseUrl, name)
+	if err != nil {
+		return keyvault.DeletedSecretBundle{}, err
+	}
+	return d, nil
+}
+
+// ListDeletedSecrets - list all deleted secrets of a keyvault with soft-delete enabled
+func (k *Keyvault) ListDeletedSecrets() ([]keyvault.DeletedSecretBundle, error) {
+
+	ctx := context.Background()
+	diter, err := k.Client.GetDeletedSecretsComplete(ctx, k.BaseUrl, nil)
+	if err != nil {
+		return []keyvault.DeletedSecretBundle{}, fmt.Errorf("unable to get list of deleted secrets: %v", err)
+	}
+
+	var d []keyvault.DeletedSecretBundle
+	for diter.NotDone() {
+		i := diter.Value()
+
+		d = append(d, keyvault.DeletedSecretBundle{
+			RecoveryID:         i.RecoveryID,
+			ScheduledPurgeDate: i.ScheduledPurgeDate,
+			DeletedDate:        i.DeletedDate,
+			ID:                 i.ID,
+			Attributes:         i.Attributes,
+			Tags:               i.Tags,
+			ContentType:        i.ContentType,
+			Managed:            i.Managed,
+		})
+		err = diter.NextWithContext(ctx)
+		if err != nil {
+			return []keyvault.DeletedSecretBundle{}, err
+		}
+	}
+
+	return d, nil
+}
+
+// RecoverDeletedSecret - recover the deleted secret
+func (k *Keyvault) RecoverDeletedSecret(name string) (keyvault.SecretBundle, error) {
+	s, err := k.Client.RecoverDeletedSecret(context.Background(), k.BaseUrl, name)
+	if err != nil {
+		return keyvault.SecretBundle{}, err
+	}
+	return s, nil
+}
+
+// PurgeDeletedSecret - permanently delete the deleted secret
+func (k *Keyvault) PurgeDeletedSecret(name string) error {
+	_, err := k.Client.PurgeDeletedSecret(context.Background(), k.BaseUrl, name)
+	return err
+}
+
 // EncryptString - encrypt a given file
 func (k *Keyvault) EncryptString(key string, version string, encoded string) (keyvault.KeyOperationResult, error) {
 
@@ -234,6 +341,62 @@ func (k *Keyvault) ListKeys() ([]keyvault.KeyBundle, error) {
 	return kb, nil
 }
 
+// DeleteKey - delete all versions of the key. In keyvaults with soft-delete enabled
+// the key can be recovered until the scheduled purge date
+func (k *Keyvault) DeleteKey(key string) (keyvault.DeletedKeyBundle, error) {
+	d, err := k.Client.DeleteKey(context.Background(), k.BaseUrl, key)
+	if err != nil {
+		return keyvault.DeletedKeyBundle{}, err
+	}
+	return d, nil
+}
+
+// ListDeletedKeys - list all deleted keys of a keyvault with soft-delete enabled
+func (k *Keyvault) ListDeletedKeys() ([]keyvault.DeletedKeyBundle, error) {
+
+	ctx := context.Background()
+	diter, err := k.Client.GetDeletedKeysComplete(ctx, k.BaseUrl, nil)
+	if err != nil {
+		return []keyvault.DeletedKeyBundle{}, fmt.Errorf("unable to get list of deleted keys: %v", err)
+	}
+
+	var d []keyvault.DeletedKeyBundle
+	for diter.NotDone() {
+		i := diter.Value()
+
+		d = append(d, keyvault.DeletedKeyBundle{
+			RecoveryID:         i.RecoveryID,
+			ScheduledPurgeDate: i.ScheduledPurgeDate,
+			DeletedDate:        i.DeletedDate,
+			Key:                &keyvault.JSONWebKey{Kid: i.Kid},
+			Attributes:         i.Attributes,
+			Tags:               i.Tags,
+			Managed:            i.Managed,
+		})
+		err = diter.NextWithContext(ctx)
+		if err != nil {
+			return []keyvault.DeletedKeyBundle{}, err
+		}
+	}
+
+	return d, nil
+}
+
+// RecoverDeletedKey - recover the deleted key
+func (k *Keyvault) RecoverDeletedKey(key string) (keyvault.KeyBundle, error) {
+	kb, err := k.Client.RecoverDeletedKey(context.Background(), k.BaseUrl, key)
+	if err != nil {
+		return keyvault.KeyBundle{}, err
+	}
+	return kb, nil
+}
+
+// PurgeDeletedKey - permanently delete the deleted key
+func (k *Keyvault) PurgeDeletedKey(key string) error {
+	_, err := k.Client.PurgeDeletedKey(context.Background(), k.BaseUrl, key)
+	return err
+}
+
 // BackupKey - Create a backup of a key which can be used for restoring
 func (k *Keyvault) BackupKey(key string) (string, error) {
 
@@ -295,3 +458,80 @@ func (k *Keyvault) RestoreKey(file string) (keyvault.KeyBundle, error) {
 
 	return s, nil
 }
+
+// GetCertificate - return a certificate object
+func (k *Keyvault) GetCertificate(name string, version string) (keyvault.CertificateBundle, error) {
+
+	c, err := k.Client.GetCertificate(context.Background(), k.BaseUrl, name, version)
+	if err != nil {
+		return keyvault.CertificateBundle{}, err
+	}
+
+	return c, nil
+}
+
+// ListCertificates - list all certificates in the specified keyvault
+func (k *Keyvault) ListCertificates() ([]keyvault.CertificateBundle, error) {
+
+	ctx := context.Background()
+	citer, err := k.Client.GetCertificatesComplete(ctx, k.BaseUrl, nil, nil)
+	if err != nil {
+		return []keyvault.CertificateBundle{}, err
+	}
+
+	var cb []keyvault.CertificateBundle
+
+	for citer.NotDone() {
+		i := citer.Value()
+
+		// the list items contain the certificate metadata, there is no need to retrieve every certificate
+		cb = append(cb, keyvault.CertificateBundle{
+			ID:             i.ID,
+			X509Thumbprint: i.X509Thumbprint,
+			Attributes:     i.Attributes,
+			Tags:           i.Tags,
+		})
+		err = citer.NextWithContext(ctx)
+		if err != nil {
+			return []keyvault.CertificateBundle{}, err
+		}
+	}
+
+	return cb, nil
+}
+
+// BackupCertificate - Create a backup of a certificate which can be used for restoring
+func (k *Keyvault) BackupCertificate(name string) (string, error) {
+
+	cb, err := k.Client.BackupCertificate(context.Background(), k.BaseUrl, name)
+	if err != nil {
+		return "", err
+	}
+
+	dec, err := base64.RawURLEncoding.DecodeString(*cb.Value)
+	if err != nil {
+		return "", err
+	}
+	return string(dec), nil
+}
+
+// RestoreCertificate - restore a certificate via backup file
+func (k *Keyvault) RestoreCertificate(file string) (keyvault.CertificateBundle, error) {
+
+	fr, err := os.ReadFile(file)
+	if err != nil {
+		return keyvault.CertificateBundle{}, err
+	}
+	fc := base64.RawURLEncoding.EncodeToString(fr)
+
+	params := keyvault.CertificateRestoreParameters{
+		CertificateBundleBackup: &fc,
+	}
+
+	c, err := k.Client.RestoreCertificate(context.Background(), k.BaseUrl, params)
+	if err != nil {
+		return keyvault.CertificateBundle{}, err
+	}
+
+	return c, nil
+}
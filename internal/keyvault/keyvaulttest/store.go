@@ -0,0 +1,271 @@
+// Package keyvaulttest provides an in memory keyvault store for tests requiring a stateful keyvault
+package keyvaulttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/date"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store - in memory secrets of a mock keyvault
+type Store struct {
+	sync.Mutex
+	Secrets map[string][]keyvault.SecretBundle
+	// versions of soft-deleted secrets
+	Deleted map[string][]keyvault.SecretBundle
+}
+
+// NewStore - return an empty store
+func NewStore() *Store {
+	return &Store{
+		Secrets: map[string][]keyvault.SecretBundle{},
+		Deleted: map[string][]keyvault.SecretBundle{},
+	}
+}
+
+// Get - return the given or the latest version of the secret
+func (s *Store) Get(name string, version string) (keyvault.SecretBundle, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	versions := s.Secrets[name]
+	if len(versions) == 0 {
+		return keyvault.SecretBundle{}, fmt.Errorf("SecretNotFound: secret %s not found", name)
+	}
+	if version == "" {
+		return versions[len(versions)-1], nil
+	}
+	for _, v := range versions {
+		if strings.HasSuffix(*v.ID, "/"+version) {
+			return v, nil
+		}
+	}
+	return keyvault.SecretBundle{}, fmt.Errorf("SecretNotFound: secret %s version %s not found", name, version)
+}
+
+// Set - add a new version of the secret
+func (s *Store) Set(kv string, name string, parameters keyvault.SecretSetParameters) keyvault.SecretBundle {
+	s.Lock()
+	defer s.Unlock()
+
+	id := fmt.Sprintf("https://%s.%s/secrets/%s/version%d", kv, azure.PublicCloud.KeyVaultDNSSuffix, name, len(s.Secrets[name])+1)
+	enabled := true
+	created := date.UnixTime(time.Now())
+	sb := keyvault.SecretBundle{
+		ID:          &id,
+		Value:       parameters.Value,
+		ContentType: parameters.ContentType,
+		Tags:        parameters.Tags,
+		Attributes:  &keyvault.SecretAttributes{Enabled: &enabled},
+	}
+	if parameters.SecretAttributes != nil {
+		attr := *parameters.SecretAttributes
+		if attr.Enabled == nil {
+			attr.Enabled = &enabled
+		}
+		sb.Attributes = &attr
+	}
+	sb.Attributes.Created = &created
+	sb.Attributes.Updated = &created
+	s.Secrets[name] = append(s.Secrets[name], sb)
+	return sb
+}
+
+// List - return the latest version of all secrets without value, sorted by name
+func (s *Store) List() []keyvault.SecretBundle {
+	s.Lock()
+	defer s.Unlock()
+
+	var names []string
+	for n := range s.Secrets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var secrets []keyvault.SecretBundle
+	for _, n := range names {
+		latest := s.Secrets[n][len(s.Secrets[n])-1]
+		id := strings.TrimSuffix(*latest.ID, "/"+path.Base(*latest.ID))
+		secrets = append(secrets, keyvault.SecretBundle{
+			ID:          &id,
+			ContentType: latest.ContentType,
+			Tags:        latest.Tags,
+			Attributes:  latest.Attributes,
+			Managed:     latest.Managed,
+		})
+	}
+	return secrets
+}
+
+// Versions - return all versions of the secret without values
+func (s *Store) Versions(name string) ([]keyvault.SecretBundle, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.Secrets[name]) == 0 {
+		return nil, fmt.Errorf("SecretNotFound: secret %s not found", name)
+	}
+	var versions []keyvault.SecretBundle
+	for _, v := range s.Secrets[name] {
+		versions = append(versions, keyvault.SecretBundle{
+			ID:          v.ID,
+			ContentType: v.ContentType,
+			Tags:        v.Tags,
+			Attributes:  v.Attributes,
+		})
+	}
+	return versions, nil
+}
+
+// Update - update the attributes of the given secret version
+func (s *Store) Update(name string, version string, parameters keyvault.SecretUpdateParameters) (keyvault.SecretBundle, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, v := range s.Secrets[name] {
+		if !strings.HasSuffix(*v.ID, "/"+version) {
+			continue
+		}
+		if parameters.SecretAttributes != nil {
+			attr := *v.Attributes
+			if parameters.SecretAttributes.Enabled != nil {
+				attr.Enabled = parameters.SecretAttributes.Enabled
+			}
+			v.Attributes = &attr
+		}
+		if parameters.Tags != nil {
+			v.Tags = parameters.Tags
+		}
+		s.Secrets[name][i] = v
+		return v, nil
+	}
+	return keyvault.SecretBundle{}, fmt.Errorf("SecretNotFound: secret %s version %s not found", name, version)
+}
+
+// Delete - soft-delete all versions of the secret
+func (s *Store) Delete(name string) (keyvault.DeletedSecretBundle, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	versions := s.Secrets[name]
+	if len(versions) == 0 {
+		return keyvault.DeletedSecretBundle{}, fmt.Errorf("SecretNotFound: secret %s not found", name)
+	}
+	delete(s.Secrets, name)
+	s.Deleted[name] = versions
+	return s.deletedBundle(name), nil
+}
+
+// deletedBundle - return the latest version of the deleted secret
+func (s *Store) deletedBundle(name string) keyvault.DeletedSecretBundle {
+	latest := s.Deleted[name][len(s.Deleted[name])-1]
+	recoveryId := strings.Replace(*latest.ID, "/secrets/", "/deletedsecrets/", 1)
+	recoveryId = strings.TrimSuffix(recoveryId, "/"+path.Base(recoveryId))
+	deleted := date.UnixTime(time.Now())
+	purge := date.UnixTime(time.Now().Add(90 * 24 * time.Hour))
+	return keyvault.DeletedSecretBundle{
+		RecoveryID:         &recoveryId,
+		DeletedDate:        &deleted,
+		ScheduledPurgeDate: &purge,
+		ID:                 latest.ID,
+		ContentType:        latest.ContentType,
+		Tags:               latest.Tags,
+		Attributes:         latest.Attributes,
+		Managed:            latest.Managed,
+	}
+}
+
+// ListDeleted - return all deleted secrets, sorted by name
+func (s *Store) ListDeleted() []keyvault.DeletedSecretBundle {
+	s.Lock()
+	defer s.Unlock()
+
+	var names []string
+	for n := range s.Deleted {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var deleted []keyvault.DeletedSecretBundle
+	for _, n := range names {
+		deleted = append(deleted, s.deletedBundle(n))
+	}
+	return deleted
+}
+
+// Recover - restore all versions of the deleted secret
+func (s *Store) Recover(name string) (keyvault.SecretBundle, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	versions := s.Deleted[name]
+	if len(versions) == 0 {
+		return keyvault.SecretBundle{}, fmt.Errorf("SecretNotFound: deleted secret %s not found", name)
+	}
+	if len(s.Secrets[name]) > 0 {
+		return keyvault.SecretBundle{}, fmt.Errorf("Conflict: secret %s already exists", name)
+	}
+	delete(s.Deleted, name)
+	s.Secrets[name] = versions
+	return versions[len(versions)-1], nil
+}
+
+// Purge - permanently delete the deleted secret
+func (s *Store) Purge(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.Deleted[name]) == 0 {
+		return fmt.Errorf("SecretNotFound: deleted secret %s not found", name)
+	}
+	delete(s.Deleted, name)
+	return nil
+}
+
+// storeBackup - content of the backup files created by the store
+type storeBackup struct {
+	Name     string                  `json:"name"`
+	Versions []keyvault.SecretBundle `json:"versions"`
+}
+
+// Backup - return all versions of the secret as backup
+func (s *Store) Backup(name string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.Secrets[name]) == 0 {
+		return "", fmt.Errorf("SecretNotFound: secret %s not found", name)
+	}
+	j, err := json.Marshal(storeBackup{Name: name, Versions: s.Secrets[name]})
+	return string(j), err
+}
+
+// Restore - restore all versions of the backup into the given keyvault
+func (s *Store) Restore(kv string, backup []byte) (keyvault.SecretBundle, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var b storeBackup
+	err := json.Unmarshal(backup, &b)
+	if err != nil || b.Name == "" || len(b.Versions) == 0 {
+		return keyvault.SecretBundle{}, autorest.DetailedError{StatusCode: http.StatusBadRequest, Message: "BadParameter: invalid backup"}
+	}
+	if len(s.Secrets[b.Name]) > 0 || len(s.Deleted[b.Name]) > 0 {
+		return keyvault.SecretBundle{}, autorest.DetailedError{StatusCode: http.StatusConflict, Message: "Conflict: secret already exists"}
+	}
+	for i, v := range b.Versions {
+		id := fmt.Sprintf("https://%s.%s/secrets/%s/%s", kv, azure.PublicCloud.KeyVaultDNSSuffix, b.Name, path.Base(*v.ID))
+		b.Versions[i].ID = &id
+	}
+	s.Secrets[b.Name] = b.Versions
+	return b.Versions[len(b.Versions)-1], nil
+}
@@ -0,0 +1,287 @@
+package structs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	mskeyvault "github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault"
+	"os"
+	"software.sslmate.com/src/go-pkcs12"
+	"strings"
+	"time"
+)
+
+const (
+	CertificateFormatPem = "pem"
+	CertificateFormatPfx = "pfx"
+	CertificateFormatCrt = "crt"
+	CertificateFormatKey = "key"
+
+	// content types of the secrets backing keyvault certificates
+	contentTypePkcs12 = "application/x-pkcs12"
+)
+
+// NewCertificate - return a Certificate struct
+func NewCertificate(kv keyvault.KeyvaultInterface, name string, version string) Certificate {
+	return Certificate{
+		Id:       NewKeyvaultObjectId(kv.GetKeyvaultName(), "certificates", name, version),
+		Name:     name,
+		KeyVault: kv,
+		Version:  version,
+	}
+}
+
+type Certificate struct {
+	Id          KeyvaultObjectId           `json:"id,omitempty"`
+	Name        string                     `json:"name,omitempty"`
+	KeyVault    keyvault.KeyvaultInterface `json:"keyvault,omitempty"`
+	Version     string                     `json:"version,omitempty"`
+	Thumbprint  string                     `json:"thumbprint,omitempty"`
+	Subject     string                     `json:"subject,omitempty"`
+	NotBefore   *JTime                     `json:"notbefore,omitempty"`
+	Expires     *JTime                     `json:"expires,omitempty"`
+	Certificate string                     `json:"certificate,omitempty"`
+}
+
+// Get - retrieve certificate from keyvault
+func (c *Certificate) Get() (Certificate, error) {
+
+	cb, err := c.KeyVault.GetCertificate(c.Name, c.Version)
+	if err != nil {
+		return Certificate{}, err
+	}
+
+	cert, err := c.fromBundle(cb.ID, cb.X509Thumbprint, cb.Attributes)
+	if err != nil {
+		return Certificate{}, err
+	}
+
+	// add subject and pem encoded public certificate
+	if cb.Cer != nil {
+		x, err := x509.ParseCertificate(*cb.Cer)
+		if err != nil {
+			return Certificate{}, err
+		}
+		cert.Subject = x.Subject.String()
+		cert.Certificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: x.Raw}))
+	}
+
+	return cert, nil
+}
+
+// fromBundle - create a certificate struct from the values returned by keyvault
+func (c *Certificate) fromBundle(id *string, thumbprint *string, attributes *mskeyvault.CertificateAttributes) (Certificate, error) {
+	coid := KeyvaultObjectId(*id)
+	ref, err := coid.ParseType("certificates")
+	if err != nil {
+		return Certificate{}, err
+	}
+
+	cert := Certificate{
+		Id:       coid,
+		Name:     ref.Name,
+		KeyVault: c.KeyVault,
+		Version:  ref.Version,
+	}
+
+	// keyvault returns the sha1 thumbprint as base64 url encoded string
+	if thumbprint != nil {
+		t, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*thumbprint, "="))
+		if err == nil {
+			cert.Thumbprint = strings.ToUpper(hex.EncodeToString(t))
+		}
+	}
+	if attributes != nil {
+		cert.NotBefore = unixTimeToJTime(attributes.NotBefore)
+		cert.Expires = unixTimeToJTime(attributes.Expires)
+	}
+
+	return cert, nil
+}
+
+// Backup - create backup of certificate and write it into the given file
+func (c *Certificate) Backup(f string) error {
+	backup, err := c.KeyVault.BackupCertificate(c.Name)
+	if err != nil {
+		return err
+	}
+
+	fp, err := os.Create(f)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	_, err = fp.WriteString(backup)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Export - return the certificate chain and private key in the given format
+//   - pem: private key and certificate chain
+//   - crt: certificate chain
+//   - key: private key
+//   - pfx: pkcs12 archive without password
+func (c *Certificate) Export(format string) (string, error) {
+
+	switch format {
+	case CertificateFormatPem, CertificateFormatPfx, CertificateFormatCrt, CertificateFormatKey:
+	default:
+		return "", fmt.Errorf("Unsupported certificate format '%s', use one of pem, pfx, crt or key", format)
+	}
+
+	// the private key and chain are stored in the secret backing the certificate
+	sb, err := c.KeyVault.GetSecret(c.Name, c.Version)
+	if err != nil {
+		// the public certificate is available without access to the backing secret
+		if format == CertificateFormatCrt {
+			cert, cerr := c.Get()
+			if cerr == nil && cert.Certificate != "" {
+				return cert.Certificate, nil
+			}
+		}
+		return "", err
+	}
+	if sb.Value == nil {
+		return "", fmt.Errorf("Certificate %s has no exportable secret", c.Name)
+	}
+
+	var key crypto.PrivateKey
+	var chain []*x509.Certificate
+	var pfx []byte
+	if sb.ContentType != nil && *sb.ContentType == contentTypePkcs12 {
+		pfx, err = base64.StdEncoding.DecodeString(*sb.Value)
+		if err != nil {
+			return "", err
+		}
+		var leaf *x509.Certificate
+		var ca []*x509.Certificate
+		key, leaf, ca, err = pkcs12.DecodeChain(pfx, "")
+		if err != nil {
+			return "", err
+		}
+		chain = append([]*x509.Certificate{leaf}, ca...)
+	} else {
+		key, chain, err = parsePemCertificate([]byte(*sb.Value))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch format {
+	case CertificateFormatCrt:
+		return encodePemChain(chain), nil
+	case CertificateFormatKey:
+		return encodePemKey(key)
+	case CertificateFormatPfx:
+		if pfx == nil {
+			pfx, err = pkcs12.Encode(rand.Reader, key, chain[0], chain[1:], "")
+			if err != nil {
+				return "", err
+			}
+		}
+		return string(pfx), nil
+	}
+
+	k, err := encodePemKey(key)
+	if err != nil {
+		return "", err
+	}
+	return k + encodePemChain(chain), nil
+}
+
+// parsePemCertificate - parse the private key and certificate chain of a pem encoded certificate
+func parsePemCertificate(data []byte) (crypto.PrivateKey, []*x509.Certificate, error) {
+	var key crypto.PrivateKey
+	var chain []*x509.Certificate
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var err error
+		switch block.Type {
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			chain = append(chain, cert)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if key == nil || len(chain) == 0 {
+		return nil, nil, errors.New("Certificate doesnt contain a private key and certificate")
+	}
+	return key, chain, nil
+}
+
+// encodePemChain - pem encode the given certificates
+func encodePemChain(chain []*x509.Certificate) string {
+	var value string
+	for _, c := range chain {
+		value += string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}))
+	}
+	return value
+}
+
+// encodePemKey - pem encode the given private key as pkcs8
+func encodePemKey(key crypto.PrivateKey) (string, error) {
+	k, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: k})), nil
+}
+
+// unixTimeToJTime - convert the optional keyvault timestamps
+func unixTimeToJTime(t *date.UnixTime) *JTime {
+	if t == nil {
+		return nil
+	}
+	j := JTime(time.Time(*t))
+	return &j
+}
+
+type CertificateList struct {
+	Certificates []Certificate `json:"certificates,omitempty"`
+}
+
+func (cl *CertificateList) List(kv keyvault.KeyvaultInterface) ([]Certificate, error) {
+
+	cb, err := kv.ListCertificates()
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates []Certificate
+	for _, b := range cb {
+		c := Certificate{KeyVault: kv}
+		c, err = c.fromBundle(b.ID, b.X509Thumbprint, b.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, c)
+	}
+
+	return certificates, nil
+}
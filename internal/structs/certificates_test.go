@@ -0,0 +1,161 @@
+package structs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"software.sslmate.com/src/go-pkcs12"
+	"strings"
+	"testing"
+	"time"
+)
+
+// certMockKeyvault - mock keyvault returning a generated certificate and its backing secret
+type certMockKeyvault struct {
+	MockKeyvault
+	cert        *x509.Certificate
+	contentType string
+	value       string
+}
+
+func (m certMockKeyvault) GetCertificate(name string, version string) (keyvault.CertificateBundle, error) {
+	cb, _ := m.MockKeyvault.GetCertificate(name, version)
+	cb.Cer = &m.cert.Raw
+	thumbprint := "AAECAwQFBgcICQoLDA0ODxAREhM"
+	cb.X509Thumbprint = &thumbprint
+	return cb, nil
+}
+
+func (m certMockKeyvault) GetSecret(name string, version string) (keyvault.SecretBundle, error) {
+	sb, _ := m.MockKeyvault.GetSecret(name, version)
+	sb.ContentType = &m.contentType
+	sb.Value = &m.value
+	return sb, nil
+}
+
+// newTestCertificate - create a self signed certificate and its private key
+func newTestCertificate(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestNewCertificate(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault"}
+	cert := NewCertificate(mock, "mycert", "myversion")
+
+	assert.Equal(KeyvaultObjectId(fmt.Sprintf("https://mykeyvault.%s/certificates/mycert/myversion", azure.PublicCloud.KeyVaultDNSSuffix)), cert.Id, "should be equal")
+	assert.Equal("mycert", cert.Name, "should be equal")
+	assert.Equal("myversion", cert.Version, "should be equal")
+}
+
+func TestCertificate_Get(t *testing.T) {
+	assert := assert.New(t)
+
+	x, _ := newTestCertificate(t)
+	mock := certMockKeyvault{MockKeyvault: MockKeyvault{Name: "mykeyvault"}, cert: x}
+	cert := NewCertificate(mock, "mycert", "myversion")
+
+	cert, err := cert.Get()
+	assert.Nil(err, "should be nil")
+	assert.Equal("mycert", cert.Name, "should be equal")
+	assert.Equal("myversion", cert.Version, "should be equal")
+	assert.Equal("CN=example.com", cert.Subject, "should be equal")
+	assert.Equal("000102030405060708090A0B0C0D0E0F10111213", cert.Thumbprint, "should be equal")
+	assert.True(strings.HasPrefix(cert.Certificate, "-----BEGIN CERTIFICATE-----"), "should be pem encoded")
+}
+
+func TestCertificate_Backup(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault"}
+	cert := NewCertificate(mock, "mycert", "")
+	tmpfile, _ := ioutil.TempFile("", "TestCertificate_Backup")
+	defer os.Remove(tmpfile.Name())
+	_ = tmpfile.Close()
+
+	// write backup data (mock keyvault returns name of certificate as backup content)
+	err := cert.Backup(tmpfile.Name())
+	backup, _ := os.ReadFile(tmpfile.Name())
+	assert.Nil(err, "should be nil")
+	assert.Equal("mycert", string(backup), "should be equal")
+}
+
+func TestCertificate_Export(t *testing.T) {
+	assert := assert.New(t)
+
+	x, key := newTestCertificate(t)
+	keyder, _ := x509.MarshalPKCS8PrivateKey(key)
+	pemkey := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyder}))
+	pemcert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: x.Raw}))
+	pfx, _ := pkcs12.Encode(rand.Reader, key, x, nil, "")
+
+	// certificates are either backed by pem or pkcs12 secrets
+	mocks := []certMockKeyvault{
+		{MockKeyvault: MockKeyvault{Name: "mykeyvault"}, cert: x, contentType: "application/x-pem-file", value: pemkey + pemcert},
+		{MockKeyvault: MockKeyvault{Name: "mykeyvault"}, cert: x, contentType: "application/x-pkcs12", value: base64.StdEncoding.EncodeToString(pfx)},
+	}
+
+	for _, mock := range mocks {
+		cert := NewCertificate(mock, "mycert", "")
+
+		crt, err := cert.Export(CertificateFormatCrt)
+		assert.Nil(err, "should be nil")
+		assert.Equal(pemcert, crt, "should be equal")
+
+		k, err := cert.Export(CertificateFormatKey)
+		assert.Nil(err, "should be nil")
+		assert.Equal(pemkey, k, "should be equal")
+
+		p, err := cert.Export(CertificateFormatPem)
+		assert.Nil(err, "should be nil")
+		assert.Equal(pemkey+pemcert, p, "should be equal")
+
+		archive, err := cert.Export(CertificateFormatPfx)
+		assert.Nil(err, "should be nil")
+		pk, pc, err := pkcs12.Decode([]byte(archive), "")
+		assert.Nil(err, "should be nil")
+		assert.Equal(x.Raw, pc.Raw, "should be equal")
+		assert.True(key.Equal(pk), "should be equal")
+
+		_, err = cert.Export("der")
+		assert.Error(err, "should be error")
+	}
+}
+
+func TestCertificateList_List(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault"}
+	cl := CertificateList{}
+	cl.Certificates, _ = cl.List(&mock)
+
+	assert.Len(cl.Certificates, 5, "should be 5")
+	assert.Equal("certificate-0", cl.Certificates[0].Name, "should be equal")
+}
@@ -0,0 +1,332 @@
+package structs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault"
+	"gopkg.in/yaml.v3"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// tags containing the chart metadata
+	ChartTagName        = "helm-chart-name"
+	ChartTagVersion     = "helm-chart-version"
+	ChartTagAppVersion  = "helm-chart-appversion"
+	ChartTagApiVersion  = "helm-chart-apiversion"
+	ChartTagDescription = "helm-chart-description"
+	ChartTagDigest      = "helm-chart-digest"
+	ChartTagCreated     = "helm-chart-created"
+	ChartTagParts       = "helm-chart-parts"
+
+	// prefix of the secrets containing charts
+	chartSecretPrefix = "helmchart-"
+	// content type of the secrets containing charts
+	contentTypeChart = "application/vnd.helm-keyvault.chart.base64"
+	// keyvault tag values are limited to 256 characters
+	maxTagValueLength = 256
+)
+
+type Chart struct {
+	Id          KeyvaultObjectId           `json:"id,omitempty"`
+	Name        string                     `json:"name,omitempty"`
+	KeyVault    keyvault.KeyvaultInterface `json:"keyvault,omitempty"`
+	ChartName   string                     `json:"chart,omitempty"`
+	Version     string                     `json:"version,omitempty"`
+	AppVersion  string                     `json:"appversion,omitempty"`
+	ApiVersion  string                     `json:"apiversion,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Digest      string                     `json:"digest,omitempty"`
+	Created     *JTime                     `json:"created,omitempty"`
+	Parts       int                        `json:"parts,omitempty"`
+}
+
+// chartMetadata - the parts of the Chart.yaml stored with the chart
+type chartMetadata struct {
+	ApiVersion  string `yaml:"apiVersion"`
+	Name        string `yaml:"name"`
+	Version     string `yaml:"version"`
+	AppVersion  string `yaml:"appVersion"`
+	Description string `yaml:"description"`
+}
+
+// ChartSecretName - return the name of the secret containing the given chart version.
+// chart names and versions can contain characters not allowed in secret names
+func ChartSecretName(name string, version string) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s", name, version)))
+	return fmt.Sprintf("%s%s", chartSecretPrefix, hex.EncodeToString(h[:16]))
+}
+
+// NewChartFromPackage - return a chart struct for the given packaged chart (.tgz)
+func NewChartFromPackage(kv keyvault.KeyvaultInterface, pkg []byte) (Chart, error) {
+	md, err := readChartMetadata(pkg)
+	if err != nil {
+		return Chart{}, err
+	}
+	if md.Name == "" || md.Version == "" {
+		return Chart{}, errors.New("Chart.yaml doesnt contain a chart name and version")
+	}
+
+	digest := sha256.Sum256(pkg)
+	created := JTime(time.Now())
+	name := ChartSecretName(md.Name, md.Version)
+	return Chart{
+		Id:          NewKeyvaultObjectId(kv.GetKeyvaultName(), "secrets", name, ""),
+		Name:        name,
+		KeyVault:    kv,
+		ChartName:   md.Name,
+		Version:     md.Version,
+		AppVersion:  md.AppVersion,
+		ApiVersion:  md.ApiVersion,
+		Description: md.Description,
+		Digest:      hex.EncodeToString(digest[:]),
+		Created:     &created,
+	}, nil
+}
+
+// readChartMetadata - read the Chart.yaml of a packaged chart
+func readChartMetadata(pkg []byte) (chartMetadata, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(pkg))
+	if err != nil {
+		return chartMetadata{}, fmt.Errorf("Invalid chart package: %v", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return chartMetadata{}, errors.New("Invalid chart package: Chart.yaml not found")
+		}
+		if err != nil {
+			return chartMetadata{}, fmt.Errorf("Invalid chart package: %v", err)
+		}
+
+		// the Chart.yaml is located in the charts root directory
+		if path.Base(h.Name) != "Chart.yaml" || strings.Count(path.Clean(h.Name), "/") != 1 {
+			continue
+		}
+		var md chartMetadata
+		err = yaml.NewDecoder(tr).Decode(&md)
+		if err != nil {
+			return chartMetadata{}, fmt.Errorf("Invalid Chart.yaml: %v", err)
+		}
+		return md, nil
+	}
+}
+
+// tags - return the chart metadata as secret tags
+func (c *Chart) tags() map[string]string {
+	tags := map[string]string{}
+	add := func(k string, v string) {
+		if v == "" {
+			return
+		}
+		if len(v) > maxTagValueLength {
+			v = v[:maxTagValueLength]
+		}
+		tags[k] = v
+	}
+	add(ChartTagName, c.ChartName)
+	add(ChartTagVersion, c.Version)
+	add(ChartTagAppVersion, c.AppVersion)
+	add(ChartTagApiVersion, c.ApiVersion)
+	add(ChartTagDescription, c.Description)
+	add(ChartTagDigest, c.Digest)
+	if c.Created != nil {
+		add(ChartTagCreated, time.Time(*c.Created).Format(time.RFC3339))
+	}
+	add(ChartTagParts, strconv.Itoa(c.Parts))
+	return tags
+}
+
+// fromTags - create a chart struct from the tags of the chart secret
+func (c *Chart) fromTags(id *string, tags map[string]*string) (Chart, error) {
+	coid := KeyvaultObjectId(*id)
+	ref, err := coid.ParseType("secrets")
+	if err != nil {
+		return Chart{}, err
+	}
+
+	t := convertTags(tags)
+	chart := Chart{
+		Id:          coid,
+		Name:        ref.Name,
+		KeyVault:    c.KeyVault,
+		ChartName:   t[ChartTagName],
+		Version:     t[ChartTagVersion],
+		AppVersion:  t[ChartTagAppVersion],
+		ApiVersion:  t[ChartTagApiVersion],
+		Description: t[ChartTagDescription],
+		Digest:      t[ChartTagDigest],
+	}
+	if created, err := time.Parse(time.RFC3339, t[ChartTagCreated]); err == nil {
+		j := JTime(created)
+		chart.Created = &j
+	}
+	chart.Parts, err = strconv.Atoi(t[ChartTagParts])
+	if err != nil || chart.Parts < 1 {
+		return Chart{}, fmt.Errorf("Secret %s doesnt contain a valid chart", ref.Name)
+	}
+	return chart, nil
+}
+
+// Push - store the packaged chart as secret with the chart metadata as tags. Charts exceeding the
+// secret size limit are split into multiple secrets like other secrets
+func (c *Chart) Push(pkg []byte) (Chart, error) {
+
+	sec := NewSecret(c.KeyVault, c.Name, "")
+	sec.ContentType = contentTypeChart
+	sec.Value = base64.StdEncoding.EncodeToString(pkg)
+
+	// the number of secrets containing the chart, split charts consist of the parts and the manifest
+	c.Parts = 1
+	if chunks := sec.chunks(); chunks != nil {
+		c.Parts = len(chunks) + 1
+	}
+	sec.Tags = c.tags()
+
+	_, err := sec.Put()
+	if err != nil {
+		return Chart{}, err
+	}
+	return c.Get()
+}
+
+// Get - retrieve the chart metadata from keyvault
+func (c *Chart) Get() (Chart, error) {
+	sb, err := c.KeyVault.GetSecret(c.Name, "")
+	if err != nil {
+		return Chart{}, err
+	}
+	return c.fromTags(sb.ID, sb.Tags)
+}
+
+// Package - retrieve the chart and return the packaged chart
+func (c *Chart) Package() ([]byte, error) {
+	chart, err := c.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	sec := NewSecret(c.KeyVault, c.Name, "")
+	sec, err = sec.Get()
+	if err != nil {
+		return nil, err
+	}
+	if sec.Value == "" {
+		return nil, fmt.Errorf("Chart %s is empty", c.Name)
+	}
+
+	pkg, err := base64.StdEncoding.DecodeString(sec.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	// a concurrent push between retrieving the metadata and the chart results in a different digest
+	digest := sha256.Sum256(pkg)
+	if hex.EncodeToString(digest[:]) != chart.Digest {
+		return nil, fmt.Errorf("Digest of chart %s %s doesnt match", chart.ChartName, chart.Version)
+	}
+	return pkg, nil
+}
+
+type ChartList struct {
+	Charts []Chart `json:"charts,omitempty"`
+}
+
+// List - list all charts stored in the keyvault, sorted by chart name and version
+func (cl *ChartList) List(kv keyvault.KeyvaultInterface) ([]Chart, error) {
+
+	sb, err := kv.ListSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	var charts []Chart
+	for _, s := range sb {
+		if s.Tags[ChartTagName] == nil || s.Tags[TagPartOf] != nil {
+			continue
+		}
+		c := Chart{KeyVault: kv}
+		c, err = c.fromTags(s.ID, s.Tags)
+		if err != nil {
+			return nil, err
+		}
+		charts = append(charts, c)
+	}
+
+	sort.Slice(charts, func(i, j int) bool {
+		if charts[i].ChartName != charts[j].ChartName {
+			return charts[i].ChartName < charts[j].ChartName
+		}
+		return charts[i].Version < charts[j].Version
+	})
+	return charts, nil
+}
+
+// chartIndexEntry - entry of a helm repository index
+type chartIndexEntry struct {
+	ApiVersion  string    `yaml:"apiVersion,omitempty"`
+	Name        string    `yaml:"name"`
+	Version     string    `yaml:"version"`
+	AppVersion  string    `yaml:"appVersion,omitempty"`
+	Description string    `yaml:"description,omitempty"`
+	Digest      string    `yaml:"digest,omitempty"`
+	Created     time.Time `yaml:"created,omitempty"`
+	Urls        []string  `yaml:"urls"`
+}
+
+// Index - return the helm repository index (index.yaml) of the given charts. The chart urls
+// are created by appending <secret name>.tgz to the given base url
+func (cl *ChartList) Index(baseUrl string) (string, error) {
+	index := struct {
+		ApiVersion string                       `yaml:"apiVersion"`
+		Entries    map[string][]chartIndexEntry `yaml:"entries"`
+		Generated  time.Time                    `yaml:"generated"`
+	}{
+		ApiVersion: "v1",
+		Entries:    map[string][]chartIndexEntry{},
+		Generated:  time.Now().UTC(),
+	}
+
+	for _, c := range cl.Charts {
+		e := chartIndexEntry{
+			ApiVersion:  c.ApiVersion,
+			Name:        c.ChartName,
+			Version:     c.Version,
+			AppVersion:  c.AppVersion,
+			Description: c.Description,
+			Digest:      c.Digest,
+			Urls:        []string{fmt.Sprintf("%s/%s.tgz", strings.TrimSuffix(baseUrl, "/"), c.Name)},
+		}
+		if c.Created != nil {
+			e.Created = time.Time(*c.Created).UTC()
+		}
+		index.Entries[c.ChartName] = append(index.Entries[c.ChartName], e)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err := enc.Encode(index)
+	if err != nil {
+		return "", err
+	}
+	err = enc.Close()
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,120 @@
+package structs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault/keyvaulttest"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// packageChart - create a packaged chart with the given Chart.yaml and a random file of the given size
+func packageChart(chartYaml string, size int) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	random := make([]byte, size)
+	_, _ = rand.Read(random)
+	files := map[string][]byte{
+		"example/Chart.yaml":            []byte(chartYaml),
+		"example/charts/dep/Chart.yaml": []byte("name: dep\nversion: 0.0.1\n"),
+		"example/templates/random.bin":  random,
+	}
+	for _, name := range []string{"example/charts/dep/Chart.yaml", "example/Chart.yaml", "example/templates/random.bin"} {
+		_ = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))})
+		_, _ = tw.Write(files[name])
+	}
+	_ = tw.Close()
+	_ = gz.Close()
+	return buf.Bytes()
+}
+
+const exampleChartYaml = "apiVersion: v2\nname: example\nversion: 1.2.3\nappVersion: \"4.5\"\ndescription: Example chart\n"
+
+func TestNewChartFromPackage(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault"}
+	chart, err := NewChartFromPackage(mock, packageChart(exampleChartYaml, 10))
+	assert.Nil(err, "should be nil")
+	assert.Equal("example", chart.ChartName, "should be equal")
+	assert.Equal("1.2.3", chart.Version, "should be equal")
+	assert.Equal("4.5", chart.AppVersion, "should be equal")
+	assert.Equal("v2", chart.ApiVersion, "should be equal")
+	assert.Equal("Example chart", chart.Description, "should be equal")
+	assert.Equal(ChartSecretName("example", "1.2.3"), chart.Name, "should be equal")
+	assert.Len(chart.Digest, 64, "should be 64")
+
+	_, err = NewChartFromPackage(mock, []byte("no chart"))
+	assert.Error(err, "should be error")
+	_, err = NewChartFromPackage(mock, packageChart("name: example\n", 10))
+	assert.Error(err, "should be error")
+}
+
+func TestChartSecretName(t *testing.T) {
+	assert := assert.New(t)
+
+	name := ChartSecretName("example", "1.2.3+build.1")
+	assert.True(strings.HasPrefix(name, "helmchart-"), "should have prefix")
+	_, err := ParseKeyvaultObjectId(fmt.Sprintf("https://mykeyvault.vault.azure.net/secrets/%s", name))
+	assert.Nil(err, "should be a valid secret name")
+	assert.NotEqual(name, ChartSecretName("example", "1.2.3"), "should not be equal")
+}
+
+func TestChart_Push(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault", Store: keyvaulttest.NewStore()}
+	pkg := packageChart(exampleChartYaml, 64*1024)
+
+	chart, err := NewChartFromPackage(mock, pkg)
+	assert.Nil(err, "should be nil")
+	chart, err = chart.Push(pkg)
+	assert.Nil(err, "should be nil")
+	assert.Greater(chart.Parts, 1, "should be split into multiple secrets")
+	assert.Len(mock.Store.Secrets, chart.Parts, "should be equal")
+
+	// the parts arent listed as charts
+	cl := ChartList{}
+	cl.Charts, err = cl.List(mock)
+	assert.Nil(err, "should be nil")
+	assert.Len(cl.Charts, 1, "should be 1")
+	assert.Equal("example", cl.Charts[0].ChartName, "should be equal")
+	assert.Equal(chart.Digest, cl.Charts[0].Digest, "should be equal")
+
+	retrieved, err := cl.Charts[0].Package()
+	assert.Nil(err, "should be nil")
+	assert.Equal(pkg, retrieved, "should be equal")
+
+	// modified parts are detected
+	value := "AAAA"
+	mock.Store.Secrets[fmt.Sprintf("%s-part-1", chart.Name)][0].Value = &value
+	_, err = cl.Charts[0].Package()
+	assert.Error(err, "should be error")
+}
+
+func TestChartList_Index(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault", Store: keyvaulttest.NewStore()}
+	for _, v := range []string{"1.0.0", "0.1.0"} {
+		pkg := packageChart(strings.Replace(exampleChartYaml, "1.2.3", v, 1), 10)
+		chart, _ := NewChartFromPackage(mock, pkg)
+		_, err := chart.Push(pkg)
+		assert.Nil(err, "should be nil")
+	}
+
+	cl := ChartList{}
+	cl.Charts, _ = cl.List(mock)
+	index, err := cl.Index("keyvault+secret://mykeyvault/")
+	assert.Nil(err, "should be nil")
+
+	assert.True(strings.HasPrefix(index, "apiVersion: v1\nentries:\n  example:\n    - apiVersion: v2\n      name: example\n      version: 0.1.0\n"), index)
+	assert.Contains(index, fmt.Sprintf("      urls:\n        - keyvault+secret://mykeyvault/%s.tgz\n", ChartSecretName("example", "1.0.0")))
+	assert.Contains(index, "generated: ")
+}
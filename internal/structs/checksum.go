@@ -0,0 +1,43 @@
+package structs
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const checksumPrefix = "hmac-sha256"
+
+// saltedChecksum - return a salted checksum of the given plaintext, format is "hmac-sha256:<salt>:<digest>".
+// the random salt prevents the checksum from being matched against precomputed hashes
+func saltedChecksum(plain []byte) (string, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%s:%s", checksumPrefix, hex.EncodeToString(salt), checksumDigest(salt, plain)), nil
+}
+
+// verifyChecksum - returns true if the salted checksum matches the given plaintext
+func verifyChecksum(checksum string, plain []byte) bool {
+	c := strings.Split(checksum, ":")
+	if len(c) != 3 || c[0] != checksumPrefix {
+		return false
+	}
+	salt, err := hex.DecodeString(c[1])
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(c[2]), []byte(checksumDigest(salt, plain)))
+}
+
+// checksumDigest - return the hex encoded hmac of the given plaintext
+func checksumDigest(salt []byte, plain []byte) string {
+	h := hmac.New(sha256.New, salt)
+	h.Write(plain)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,29 @@
+package structs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func Test_saltedChecksum(t *testing.T) {
+	assert := assert.New(t)
+
+	checksum, err := saltedChecksum([]byte("key: value"))
+	assert.Nil(err, "should be nil")
+	assert.True(strings.HasPrefix(checksum, "hmac-sha256:"), "should be prefixed")
+	assert.True(verifyChecksum(checksum, []byte("key: value")), "should be true")
+	assert.False(verifyChecksum(checksum, []byte("key: other")), "should be false")
+
+	// the salt is random, the same content results in different checksums
+	other, _ := saltedChecksum([]byte("key: value"))
+	assert.NotEqual(checksum, other, "should not be equal")
+}
+
+func Test_verifyChecksum_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []string{"", "sha256:1234", "hmac-sha256:nohex:1234", "hmac-sha256:00"} {
+		assert.False(verifyChecksum(c, []byte("key: value")), "should be false")
+	}
+}
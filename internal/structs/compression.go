@@ -0,0 +1,82 @@
+package structs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+)
+
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// ValidateCompression - return an error if the given compression algorithm is unsupported
+func ValidateCompression(algo string) error {
+	switch algo {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("Unsupported compression '%s', use '%s' or '%s'", algo, CompressionGzip, CompressionZstd)
+}
+
+// compress - compress the given data with the given algorithm
+func compress(algo string, data []byte) ([]byte, error) {
+	err := ValidateCompression(algo)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch algo {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		w, err = gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	case CompressionZstd:
+		w, err = zstd.NewWriter(&buf, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress - decompress the given data with the given algorithm
+func decompress(algo string, data []byte) ([]byte, error) {
+	err := ValidateCompression(algo)
+	if err != nil {
+		return nil, err
+	}
+
+	switch algo {
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case CompressionZstd:
+		r, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	return data, nil
+}
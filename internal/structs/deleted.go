@@ -0,0 +1,48 @@
+package structs
+
+import (
+	"github.com/Azure/go-autorest/autorest/date"
+	"time"
+)
+
+// DeletedObject - deleted secret or key. Deleted objects of keyvaults with soft-delete enabled
+// can be recovered until the scheduled purge date
+type DeletedObject struct {
+	Id             KeyvaultObjectId `json:"id,omitempty"`
+	Name           string           `json:"name,omitempty"`
+	RecoveryId     string           `json:"recoveryid,omitempty"`
+	Deleted        *JTime           `json:"deleted,omitempty"`
+	ScheduledPurge *JTime           `json:"scheduledpurge,omitempty"`
+}
+
+// newDeletedObject - return the deleted object of the given type
+func newDeletedObject(objectType string, id *string, recoveryId *string, deleted *date.UnixTime, purge *date.UnixTime) (DeletedObject, error) {
+	oid := KeyvaultObjectId(*id)
+	ref, err := oid.ParseType(objectType)
+	if err != nil {
+		return DeletedObject{}, err
+	}
+
+	d := DeletedObject{Id: oid, Name: ref.Name}
+	if recoveryId != nil {
+		d.RecoveryId = *recoveryId
+	}
+	if deleted != nil {
+		t := JTime(time.Time(*deleted))
+		d.Deleted = &t
+	}
+	if purge != nil {
+		t := JTime(time.Time(*purge))
+		d.ScheduledPurge = &t
+	}
+	return d, nil
+}
+
+// Recoverable - returns true if the object was deleted in a keyvault with soft-delete enabled
+func (d DeletedObject) Recoverable() bool {
+	return d.RecoveryId != ""
+}
+
+type DeletedList struct {
+	Deleted []DeletedObject `json:"deleted,omitempty"`
+}
@@ -13,6 +13,7 @@ type EncryptedFile struct {
 	Kid           KeyvaultObjectId `json:"kid,omitempty"`
 	EncodedData   []string         `json:"-"`
 	EncryptedData []string         `json:"chunks,omitempty"`
+	Compression   string           `json:"compression,omitempty"`
 	LastModified  JTime            `json:"lastmodified,omitempty"`
 }
 
@@ -39,6 +40,16 @@ func (e *EncryptedFile) LoadFile(f string) ([]string, error) {
 		return nil, err
 	}
 
+	return e.EncodeData(c)
+}
+
+// EncodeData - compress the given data if required, split it into chunks and base64 encode them
+func (e *EncryptedFile) EncodeData(c []byte) ([]string, error) {
+	c, err := compress(e.Compression, c)
+	if err != nil {
+		return nil, err
+	}
+
 	// calculate max size (based on 4096bit keys) for data chunks
 	// https://stackoverflow.com/questions/1496793/rsa-encryption-getting-bad-length
 	chunksize := ((4096 - 384) / 8) + 6
@@ -57,8 +68,13 @@ func (e *EncryptedFile) LoadEncryptedFile(f string) (EncryptedFile, error) {
 		return EncryptedFile{}, err
 	}
 
+	return e.ParseEncryptedFile(c)
+}
+
+// ParseEncryptedFile - parse the content of an encrypted file, e.g. retrieved from a remote location
+func (e *EncryptedFile) ParseEncryptedFile(c []byte) (EncryptedFile, error) {
 	var value EncryptedFile
-	err = json.Unmarshal(c, &value)
+	err := json.Unmarshal(c, &value)
 	if err != nil {
 		return EncryptedFile{}, err
 	}
@@ -97,14 +113,19 @@ func (e *EncryptedFile) DecryptData(kv keyvault.KeyvaultInterface, key string, v
 	return value, nil
 }
 
-// WriteFile - Write marshalled file to disk
+// WriteEncryptedFile - Write marshalled file to disk, suffixed with .enc
 func (e *EncryptedFile) WriteEncryptedFile(f string) error {
+	return e.SaveEncryptedFile(fmt.Sprintf("%s.enc", f))
+}
+
+// SaveEncryptedFile - Write marshalled file to the given path
+func (e *EncryptedFile) SaveEncryptedFile(f string) error {
 	j, err := json.MarshalIndent(e, "", " ")
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(fmt.Sprintf("%s.enc", f), j, 0644)
+	err = os.WriteFile(f, j, 0644)
 	return err
 }
 
@@ -128,16 +149,21 @@ func (e *EncryptedFile) WriteFile(f string) error {
 	return nil
 }
 
-// GetDecodedString - Returns the base64 decoded string
+// GetDecodedString - Returns the base64 decoded and decompressed string
 func (e *EncryptedFile) GetDecodedString() (string, error) {
-	var value string
+	var value []byte
 	for _, chunk := range e.EncodedData {
 		c, err := base64.RawURLEncoding.DecodeString(chunk)
 		if err != nil {
 			return "", err
 		}
 
-		value = fmt.Sprintf("%s%s", value, c)
+		value = append(value, c...)
 	}
-	return value, nil
+
+	value, err := decompress(e.Compression, value)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
 }
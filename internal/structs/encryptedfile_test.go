@@ -165,3 +165,32 @@ func TestEncryptedFile_WriteEncryptedFile(t *testing.T) {
 	assert.Equal(encfilewritten.EncryptedData[1], encfile.EncryptedData[1])
 	assert.Equal(encfilewritten.EncryptedData[2], encfile.EncryptedData[2])
 }
+
+func TestEncryptedFile_EncodeData_Compression(t *testing.T) {
+	assert := assert.New(t)
+
+	content := strings.Repeat("key: value\n", 500)
+
+	for _, algo := range []string{CompressionNone, CompressionGzip, CompressionZstd} {
+		encfile := EncryptedFile{Compression: algo}
+		encoded, err := encfile.EncodeData([]byte(content))
+		assert.Nil(err, "should be nil")
+
+		encfile.EncodedData = encoded
+		dec, err := encfile.GetDecodedString()
+		assert.Nil(err, "should be nil")
+		assert.Equal(content, dec, "should be equal")
+		if algo != CompressionNone {
+			assert.Len(encoded, 1, "compressed content should fit into a single chunk")
+		}
+	}
+}
+
+func TestEncryptedFile_EncodeData_UnsupportedCompression(t *testing.T) {
+	assert := assert.New(t)
+
+	encfile := EncryptedFile{Compression: "lzma"}
+	encoded, err := encfile.EncodeData([]byte("key: value"))
+	assert.Nil(encoded, "should be nil")
+	assert.Error(err, "should be error")
+}
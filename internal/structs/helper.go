@@ -17,3 +17,13 @@ func splitPath(p string) ([]string, error) {
 	}
 	return paths, nil
 }
+
+// contains - returns true if the given slice contains the given string
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -2,28 +2,41 @@ package structs
 
 import (
 	"fmt"
+	"strconv"
 	"time"
 )
 
-const tformat = "2006-01-02T15:04:05Z07:0"
+// tformat - RFC3339 timestamps are written, tformatLegacy is only used to read files
+// written by older versions which dropped the minutes of the zone offset ("+01:0", "Z:0")
+const (
+	tformat       = time.RFC3339
+	tformatLegacy = "2006-01-02T15:04:05Z07:0"
+)
+
+// tformatsParse - accepted timestamp formats when reading, RFC3339 parsing accepts fractional seconds
+var tformatsParse = []string{tformat, time.RFC3339Nano, tformatLegacy}
 
 type JTime time.Time
 
 func (t JTime) MarshalJSON() ([]byte, error) {
-	//do your serializing here
-
 	stamp := fmt.Sprintf("\"%s\"", time.Time(t).Format(tformat))
 	return []byte(stamp), nil
 }
 
 func (t *JTime) UnmarshalJSON(data []byte) error {
-	//do your serializing here
-	stamp, err := time.Parse(fmt.Sprintf("\"%s\"", tformat), string(data))
+	s, err := strconv.Unquote(string(data))
 	if err != nil {
-		return err
+		return fmt.Errorf("Invalid timestamp %s: %v", string(data), err)
+	}
+
+	for _, f := range tformatsParse {
+		stamp, err := time.Parse(f, s)
+		if err == nil {
+			*t = JTime(stamp)
+			return nil
+		}
 	}
-	*t = JTime(stamp)
-	return nil
+	return fmt.Errorf("Invalid timestamp %s, expected RFC3339 format", string(data))
 }
 
 func (t JTime) String() string {
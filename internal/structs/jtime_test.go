@@ -23,14 +23,68 @@ func TestJTime_MarshalJSON(t *testing.T) {
 
 	stamp, _ := jtime.MarshalJSON()
 
-	assert.Equal("\"2021-12-31T12:00:00Z:0\"", string(stamp), "should be equal")
+	assert.Equal("\"2021-12-31T12:00:00Z\"", string(stamp), "should be equal")
+
+	jtime = JTime(timeparsed.In(time.FixedZone("", 5400)))
+	stamp, _ = jtime.MarshalJSON()
+
+	assert.Equal("\"2021-12-31T13:30:00+01:30\"", string(stamp), "should be equal")
 }
 
 func TestJTime_UnmarshalJSON(t *testing.T) {
 	assert := assert.New(t)
 
-	jtime := JTime{}
-	_ = jtime.UnmarshalJSON([]byte("\"2021-12-31T12:00:00Z:0\""))
+	tests := map[string]string{
+		"\"2021-12-31T12:00:00Z\"":           "2021-12-31T12:00:00Z",
+		"\"2021-12-31T12:00:00+01:00\"":      "2021-12-31T12:00:00+01:00",
+		"\"2021-12-31T12:00:00.123Z\"":       "2021-12-31T12:00:00Z",
+		"\"2021-12-31T12:00:00.123456789Z\"": "2021-12-31T12:00:00Z",
+		"\"2021-12-31T12:00:00.5-05:30\"":    "2021-12-31T12:00:00-05:30",
+		"\"2021-12-31T12:00:00Z:0\"":         "2021-12-31T12:00:00Z",
+		"\"2021-12-20T21:11:28+01:0\"":       "2021-12-20T21:11:28+01:00",
+	}
+
+	for data, expected := range tests {
+		jtime := JTime{}
+		err := jtime.UnmarshalJSON([]byte(data))
+		assert.Nil(err, "should be nil")
+		assert.Equal(expected, jtime.String(), "should be equal")
+	}
+}
+
+func TestJTime_UnmarshalJSON_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, data := range []string{"2021-12-31T12:00:00Z", "\"\"", "\"2021-12-31\"", "\"31.12.2021 12:00\"", "null"} {
+		jtime := JTime{}
+		err := jtime.UnmarshalJSON([]byte(data))
+		assert.Error(err, "should be error")
+	}
+}
+
+func FuzzJTime_UnmarshalJSON(f *testing.F) {
+	f.Add([]byte("\"2021-12-31T12:00:00Z\""))
+	f.Add([]byte("\"2021-12-31T12:00:00.123+01:00\""))
+	f.Add([]byte("\"2021-12-20T21:11:28+01:0\""))
+	f.Add([]byte("\"2021-12-31T12:00:00Z:0\""))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		jtime := JTime{}
+		if err := jtime.UnmarshalJSON(data); err != nil {
+			return
+		}
 
-	assert.Equal("2021-12-31T12:00:00Z:0", jtime.String(), "should be equal")
+		// every accepted timestamp has to survive a roundtrip in the written format
+		stamp, err := jtime.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed := JTime{}
+		if err := parsed.UnmarshalJSON(stamp); err != nil {
+			t.Fatalf("unable to parse written timestamp %s: %v", stamp, err)
+		}
+		if !time.Time(parsed).Equal(time.Time(jtime).Truncate(time.Second)) {
+			t.Fatalf("roundtrip mismatch %s != %s", parsed, jtime)
+		}
+	})
 }
@@ -43,6 +43,26 @@ func (k *Key) Backup(f string) error {
 	return nil
 }
 
+// RestoreKey - restore the key from the given backup file into the keyvault
+func RestoreKey(kv keyvault.KeyvaultInterface, f string) (Key, error) {
+	kb, err := kv.RestoreKey(f)
+	if err != nil {
+		return Key{}, restoreError("key", kv.GetKeyvaultName(), err)
+	}
+
+	koid := KeyvaultObjectId(*kb.Key.Kid)
+	ref, err := koid.ParseType("keys")
+	if err != nil {
+		return Key{}, err
+	}
+	return Key{
+		Kid:      koid,
+		Name:     ref.Name,
+		KeyVault: kv,
+		Version:  ref.Version,
+	}, nil
+}
+
 // Get - Retrieve key information from keyvault
 func (k *Key) Get() (Key, error) {
 	kb, err := k.KeyVault.GetKey(k.Name, k.Version)
@@ -51,11 +71,15 @@ func (k *Key) Get() (Key, error) {
 	}
 
 	koid := KeyvaultObjectId(*kb.Key.Kid)
+	ref, err := koid.ParseType("keys")
+	if err != nil {
+		return Key{}, err
+	}
 	return Key{
 		Kid:      koid,
-		Name:     koid.GetName(),
+		Name:     ref.Name,
 		KeyVault: k.KeyVault,
-		Version:  koid.GetVersion(),
+		Version:  ref.Version,
 	}, nil
 }
 
@@ -73,13 +97,51 @@ func (k *Key) Create() (Key, error) {
 	}
 
 	koid := KeyvaultObjectId(*kb.Key.Kid)
+	ref, err := koid.ParseType("keys")
+	if err != nil {
+		return Key{}, err
+	}
+	return Key{
+		Kid:      koid,
+		Name:     ref.Name,
+		KeyVault: k.KeyVault,
+		Version:  ref.Version,
+	}, nil
+
+}
+
+// Delete - delete all versions of the key
+func (k *Key) Delete() (DeletedObject, error) {
+	d, err := k.KeyVault.DeleteKey(k.Name)
+	if err != nil {
+		return DeletedObject{}, err
+	}
+	return newDeletedObject("keys", d.Key.Kid, d.RecoveryID, d.DeletedDate, d.ScheduledPurgeDate)
+}
+
+// Recover - recover the deleted key
+func (k *Key) Recover() (Key, error) {
+	kb, err := k.KeyVault.RecoverDeletedKey(k.Name)
+	if err != nil {
+		return Key{}, err
+	}
+
+	koid := KeyvaultObjectId(*kb.Key.Kid)
+	ref, err := koid.ParseType("keys")
+	if err != nil {
+		return Key{}, err
+	}
 	return Key{
 		Kid:      koid,
-		Name:     koid.GetName(),
+		Name:     ref.Name,
 		KeyVault: k.KeyVault,
-		Version:  koid.GetVersion(),
+		Version:  ref.Version,
 	}, nil
+}
 
+// Purge - permanently delete the deleted key
+func (k *Key) Purge() error {
+	return k.KeyVault.PurgeDeletedKey(k.Name)
 }
 
 type KeyList struct {
@@ -95,17 +157,37 @@ func (sl *KeyList) List(kv keyvault.KeyvaultInterface) ([]Key, error) {
 	var keys []Key
 	for _, k := range sk {
 		koid := KeyvaultObjectId(*k.Key.Kid)
-		sn := koid.GetName()
-		sve := koid.GetVersion()
+		ref, err := koid.ParseType("keys")
+		if err != nil {
+			return nil, err
+		}
 
 		keys = append(keys,
 			Key{
 				Kid:      koid,
-				Name:     sn,
+				Name:     ref.Name,
 				KeyVault: kv,
-				Version:  sve,
+				Version:  ref.Version,
 			})
 	}
 
 	return keys, nil
 }
+
+// Deleted - list all deleted keys of a keyvault with soft-delete enabled
+func (sl *KeyList) Deleted(kv keyvault.KeyvaultInterface) ([]DeletedObject, error) {
+	dk, err := kv.ListDeletedKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []DeletedObject
+	for _, k := range dk {
+		d, err := newDeletedObject("keys", k.Key.Kid, k.RecoveryID, k.DeletedDate, k.ScheduledPurgeDate)
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, d)
+	}
+	return deleted, nil
+}
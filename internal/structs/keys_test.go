@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -71,3 +72,51 @@ func TestKeyList_List(t *testing.T) {
 
 	assert.Len(kl.Keys, 5, "should be 5")
 }
+
+func TestKey_DeleteRecoverPurge(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault"}
+	key := NewKey(mock, "mykey", "")
+
+	d, err := key.Delete()
+	assert.Nil(err, "should be nil")
+	assert.Equal("mykey", d.Name, "should be equal")
+	assert.True(d.Recoverable(), "should be recoverable")
+	assert.Equal("2030-01-01T00:00:00Z", d.ScheduledPurge.String(), "should be equal")
+
+	kl := KeyList{}
+	deleted, err := kl.Deleted(mock)
+	assert.Nil(err, "should be nil")
+	assert.Len(deleted, 1, "should be 1")
+
+	k, err := key.Recover()
+	assert.Nil(err, "should be nil")
+	assert.Equal("mykey", k.Name, "should be equal")
+	assert.Equal("123456789", k.Version, "should be equal")
+
+	assert.Nil(key.Purge(), "should be nil")
+}
+
+func TestRestoreKey(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault"}
+	f := filepath.Join(t.TempDir(), "MYKEY.pem")
+	key := NewKey(mock, "mykey", "")
+	_ = key.Backup(f)
+
+	k, err := RestoreKey(mock, f)
+	assert.Nil(err, "should be nil")
+	assert.Equal("mykey", k.Name, "should be equal")
+
+	// the keys listed by the mock keyvault already exist
+	existing := NewKey(mock, "key-1", "")
+	_ = existing.Backup(f)
+	_, err = RestoreKey(mock, f)
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), "already exists", "should contain the conflict")
+
+	_, err = RestoreKey(mock, filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(err, "should be error")
+}
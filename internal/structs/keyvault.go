@@ -1,11 +1,15 @@
 package structs
 
 import (
+	"errors"
 	"fmt"
-	mskeyvault "github.com/Azure/azure-sdk-for-go/profiles/latest/keyvault/keyvault"
+	mskeyvault "github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault"
+	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
 )
 
@@ -25,38 +29,181 @@ var NewKeyVault = func(name string) (keyvault.KeyvaultInterface, error) {
 	return &kv, nil
 }
 
-//https://<keyvault-name>.vault.azure.net/<type>/<objectname>/<objectversion>"
+var (
+	// keyvault object types
+	objectTypes = []string{"keys", "secrets", "certificates"}
+	// dns suffixes of the azure clouds
+	keyvaultDNSSuffixes = []string{
+		azure.PublicCloud.KeyVaultDNSSuffix,
+		azure.ChinaCloud.KeyVaultDNSSuffix,
+		azure.USGovernmentCloud.KeyVaultDNSSuffix,
+		azure.GermanCloud.KeyVaultDNSSuffix,
+	}
+	// https://docs.microsoft.com/en-us/azure/azure-resource-manager/management/resource-name-rules#microsoftkeyvault
+	keyvaultNameRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]{1,22}[a-zA-Z0-9]$`)
+	objectNameRegexp   = regexp.MustCompile(`^[a-zA-Z0-9-]{1,127}$`)
+	versionRegexp      = regexp.MustCompile(`^[a-zA-Z0-9]{0,64}$`)
+)
+
+// https://<keyvault-name>.vault.azure.net/<type>/<objectname>/<objectversion>"
 type KeyvaultObjectId string
 
+// ObjectRef - validated reference to a keyvault object
+type ObjectRef struct {
+	Keyvault string
+	Host     string
+	Type     string
+	Name     string
+	Version  string
+}
+
+// String - return the object id of the reference
+func (o ObjectRef) String() string {
+	return fmt.Sprintf("https://%s/%s/%s/%s", o.Host, o.Type, o.Name, o.Version)
+}
+
+// ParseKeyvaultHost - validate the host of a keyvault and return the name of the keyvault
+func ParseKeyvaultHost(host string) (string, error) {
+	h := strings.SplitN(strings.ToLower(host), ".", 2)
+	if len(h) != 2 || !contains(keyvaultDNSSuffixes, h[1]) {
+		return "", fmt.Errorf("host needs to be <keyvault>.<%s>", strings.Join(keyvaultDNSSuffixes, "|"))
+	}
+	if !keyvaultNameRegexp.MatchString(h[0]) || strings.Contains(h[0], "--") {
+		return "", fmt.Errorf("invalid keyvault name '%s'", h[0])
+	}
+	return h[0], nil
+}
+
+// ParseKeyvaultObjectId - parse and validate the given keyvault object id
+func ParseKeyvaultObjectId(id string) (ObjectRef, error) {
+	invalid := func(reason string) (ObjectRef, error) {
+		return ObjectRef{}, fmt.Errorf("Invalid keyvault object id '%s': %s", id, reason)
+	}
+
+	u, err := url.Parse(id)
+	if err != nil {
+		return invalid(err.Error())
+	}
+	if u.Scheme != "https" {
+		return invalid("scheme needs to be https")
+	}
+	if u.User != nil || u.Port() != "" || u.RawQuery != "" || u.Fragment != "" {
+		return invalid("unexpected user, port, query or fragment")
+	}
+
+	ref := ObjectRef{Host: strings.ToLower(u.Host)}
+	ref.Keyvault, err = ParseKeyvaultHost(ref.Host)
+	if err != nil {
+		return invalid(err.Error())
+	}
+
+	p, err := splitPath(u.Path)
+	if err != nil {
+		return invalid(err.Error())
+	}
+	if p[0] != "" {
+		return invalid("path needs to be absolute")
+	}
+	ref.Type = p[1]
+	ref.Name = p[2]
+	if len(p) == 4 {
+		ref.Version = p[3]
+	}
+	if !contains(objectTypes, ref.Type) {
+		return invalid(fmt.Sprintf("object type needs to be one of %s", strings.Join(objectTypes, ", ")))
+	}
+	if !objectNameRegexp.MatchString(ref.Name) {
+		return invalid(fmt.Sprintf("invalid object name '%s'", ref.Name))
+	}
+	if !versionRegexp.MatchString(ref.Version) {
+		return invalid(fmt.Sprintf("invalid object version '%s'", ref.Version))
+	}
+
+	return ref, nil
+}
+
 // NewKeyVaultObjectId - Return a Keyvault Id
 func NewKeyvaultObjectId(kv string, ty string, name string, ver string) KeyvaultObjectId {
 	return KeyvaultObjectId(fmt.Sprintf("https://%s.%s/%s/%s/%s", kv, azure.PublicCloud.KeyVaultDNSSuffix, ty, name, ver))
 }
 
-// GetKeyvault - Get the keyvault name from the ObjectId
+// Parse - parse and validate the ObjectId
+func (k *KeyvaultObjectId) Parse() (ObjectRef, error) {
+	return ParseKeyvaultObjectId(string(*k))
+}
+
+// ParseType - parse and validate the ObjectId and ensure it references an object of the given type
+func (k *KeyvaultObjectId) ParseType(ty string) (ObjectRef, error) {
+	ref, err := k.Parse()
+	if err != nil {
+		return ObjectRef{}, err
+	}
+	if ref.Type != ty {
+		return ObjectRef{}, fmt.Errorf("Invalid keyvault object id '%s': expected object type %s", string(*k), ty)
+	}
+	return ref, nil
+}
+
+// GetKeyvault - Get the keyvault name from the ObjectId, returns an empty string for invalid ids
 func (k *KeyvaultObjectId) GetKeyvault() string {
-	kv, _ := url.Parse(string(*k))
+	kv, err := url.Parse(string(*k))
+	if err != nil {
+		return ""
+	}
 	h := strings.Split(kv.Host, ".")
 	return h[0]
 }
 
+// GetType - Get the object type from the ObjectId, returns an empty string for invalid ids
 func (k *KeyvaultObjectId) GetType() string {
-	kv, _ := url.Parse(string(*k))
-	p, _ := splitPath(kv.Path)
-	return p[1]
+	return k.pathElement(1)
 }
 
+// GetName - Get the object name from the ObjectId, returns an empty string for invalid ids
 func (k *KeyvaultObjectId) GetName() string {
-	kv, _ := url.Parse(string(*k))
-	p, _ := splitPath(kv.Path)
-	return p[2]
+	return k.pathElement(2)
 }
 
+// GetVersion - Get the object version from the ObjectId, returns an empty string for invalid ids
 func (k *KeyvaultObjectId) GetVersion() string {
-	kv, _ := url.Parse(string(*k))
-	p, _ := splitPath(kv.Path)
-	if len(p) < 4 {
+	return k.pathElement(3)
+}
+
+// pathElement - return the n-th element of the ObjectIds path
+func (k *KeyvaultObjectId) pathElement(n int) string {
+	kv, err := url.Parse(string(*k))
+	if err != nil {
 		return ""
 	}
-	return p[3]
+	p, err := splitPath(kv.Path)
+	if err != nil || len(p) <= n {
+		return ""
+	}
+	return p[n]
+}
+
+// isManaged - returns true if the keyvault object is managed by a certificate
+func isManaged(managed *bool) bool {
+	return managed != nil && *managed
+}
+
+// isConflict - returns true if the keyvault request failed with a conflict, e.g. because the object exists
+func isConflict(err error) bool {
+	var de autorest.DetailedError
+	return errors.As(err, &de) && de.StatusCode == http.StatusConflict
+}
+
+// restoreError - return a descriptive error for backups which cant be restored into the keyvault
+func restoreError(objectType string, kv string, err error) error {
+	var de autorest.DetailedError
+	if !errors.As(err, &de) {
+		return err
+	}
+	switch de.StatusCode {
+	case http.StatusConflict:
+		return fmt.Errorf("Unable to restore %s into keyvault %s, a %s with the same name already exists or is deleted but not purged: %w", objectType, kv, objectType, err)
+	case http.StatusBadRequest:
+		return fmt.Errorf("Unable to restore %s into keyvault %s, backups can only be restored into keyvaults of the same subscription and geography: %w", objectType, kv, err)
+	}
+	return err
 }
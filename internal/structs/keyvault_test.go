@@ -2,17 +2,24 @@ package structs
 
 import (
 	"fmt"
-	"github.com/Azure/azure-sdk-for-go/profiles/latest/keyvault/keyvault"
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault/keyvaulttest"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
 	"testing"
+	"time"
 )
 
 // mocking keyvault until i figure out how to mock the real deal.
 type MockKeyvault struct {
 	Name    string
 	BaseUrl string
+	// optional in memory secrets, without store the mock returns fixed values
+	Store *keyvaulttest.Store `json:"-"`
 }
 
 func (m MockKeyvault) SetKeyvaultName(name string) {
@@ -56,6 +63,9 @@ func (m MockKeyvault) BackupKey(key string) (string, error) {
 }
 
 func (m MockKeyvault) BackupSecret(secret string) (string, error) {
+	if m.Store != nil {
+		return m.Store.Backup(secret)
+	}
 	return secret, nil
 }
 
@@ -83,6 +93,9 @@ func (m MockKeyvault) GetKeyvaultName() string {
 }
 
 func (m MockKeyvault) GetSecret(name string, version string) (keyvault.SecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.Get(name, version)
+	}
 
 	id := fmt.Sprintf("https://%s.%s/secrets/%s/%s", m.Name, azure.PublicCloud.KeyVaultDNSSuffix, name, version)
 	value := "My little secret!"
@@ -95,19 +108,22 @@ func (m MockKeyvault) GetSecret(name string, version string) (keyvault.SecretBun
 	return secret, nil
 }
 
-func (m MockKeyvault) PutSecret(name string, value string) (keyvault.SecretBundle, error) {
-
-	id := fmt.Sprintf("https://%s.%s/secrets/%s/%s", m.Name, azure.PublicCloud.KeyVaultDNSSuffix, name, "myversion")
-
-	secret := keyvault.SecretBundle{
-		ID:    &id,
-		Value: &value,
+func (m MockKeyvault) SetSecret(name string, parameters keyvault.SecretSetParameters) (keyvault.SecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.Set(m.Name, name, parameters), nil
 	}
-
-	return secret, nil
+	id := fmt.Sprintf("https://%s.%s/secrets/%s/%s", m.Name, azure.PublicCloud.KeyVaultDNSSuffix, name, "myversion")
+	return keyvault.SecretBundle{
+		ID:          &id,
+		Value:       parameters.Value,
+		ContentType: parameters.ContentType,
+	}, nil
 }
 
 func (m MockKeyvault) ListSecrets() ([]keyvault.SecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.List(), nil
+	}
 
 	var secrets []keyvault.SecretBundle
 
@@ -120,15 +136,158 @@ func (m MockKeyvault) ListSecrets() ([]keyvault.SecretBundle, error) {
 			"123456789",
 		)
 		val := fmt.Sprintf("My N-th (%v) secret", i)
+		env := "dev"
+		if i%2 == 0 {
+			env = "prod"
+		}
+		// the last secret is disabled
+		enabled := i != 4
 		secrets = append(secrets, keyvault.SecretBundle{
-			ID:    &id,
-			Value: &val,
+			ID:         &id,
+			Value:      &val,
+			Tags:       map[string]*string{"env": &env},
+			Attributes: &keyvault.SecretAttributes{Enabled: &enabled},
 		})
 	}
 
 	return secrets, nil
 }
 
+func (m MockKeyvault) GetCertificate(name string, version string) (keyvault.CertificateBundle, error) {
+
+	id := fmt.Sprintf("https://%s.%s/certificates/%s/%s", m.Name, azure.PublicCloud.KeyVaultDNSSuffix, name, version)
+
+	return keyvault.CertificateBundle{
+		ID: &id,
+	}, nil
+}
+
+func (m MockKeyvault) ListCertificates() ([]keyvault.CertificateBundle, error) {
+	var certificates []keyvault.CertificateBundle
+
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf(
+			"https://%s.%s/certificates/%s/%s",
+			m.Name,
+			azure.PublicCloud.KeyVaultDNSSuffix,
+			fmt.Sprintf("certificate-%v", i),
+			"123456789",
+		)
+		certificates = append(certificates, keyvault.CertificateBundle{
+			ID: &id,
+		})
+	}
+
+	return certificates, nil
+}
+
+func (m MockKeyvault) BackupCertificate(name string) (string, error) {
+	return name, nil
+}
+
+func (m MockKeyvault) RestoreCertificate(file string) (keyvault.CertificateBundle, error) {
+	return m.GetCertificate("restored", "123456789")
+}
+
+func (m MockKeyvault) ListSecretVersions(name string) ([]keyvault.SecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.Versions(name)
+	}
+	sb, err := m.GetSecret(name, "123456")
+	sb.Value = nil
+	return []keyvault.SecretBundle{sb}, err
+}
+
+func (m MockKeyvault) UpdateSecret(name string, version string, parameters keyvault.SecretUpdateParameters) (keyvault.SecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.Update(name, version, parameters)
+	}
+	return m.GetSecret(name, version)
+}
+
+func (m MockKeyvault) DeleteSecret(name string) (keyvault.DeletedSecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.Delete(name)
+	}
+	sb, err := m.GetSecret(name, "123456")
+	return keyvault.DeletedSecretBundle{ID: sb.ID}, err
+}
+
+func (m MockKeyvault) ListDeletedSecrets() ([]keyvault.DeletedSecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.ListDeleted(), nil
+	}
+	return nil, nil
+}
+
+func (m MockKeyvault) RecoverDeletedSecret(name string) (keyvault.SecretBundle, error) {
+	if m.Store != nil {
+		return m.Store.Recover(name)
+	}
+	return m.GetSecret(name, "123456")
+}
+
+func (m MockKeyvault) PurgeDeletedSecret(name string) error {
+	if m.Store != nil {
+		return m.Store.Purge(name)
+	}
+	return nil
+}
+
+func (m MockKeyvault) DeleteKey(key string) (keyvault.DeletedKeyBundle, error) {
+	id := fmt.Sprintf("https://%s.%s/keys/%s/%s", m.Name, azure.PublicCloud.KeyVaultDNSSuffix, key, "123456789")
+	recoveryId := fmt.Sprintf("https://%s.%s/deletedkeys/%s", m.Name, azure.PublicCloud.KeyVaultDNSSuffix, key)
+	purge := date.UnixTime(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	return keyvault.DeletedKeyBundle{
+		RecoveryID:         &recoveryId,
+		ScheduledPurgeDate: &purge,
+		Key:                &keyvault.JSONWebKey{Kid: &id},
+	}, nil
+}
+
+func (m MockKeyvault) ListDeletedKeys() ([]keyvault.DeletedKeyBundle, error) {
+	dk, err := m.DeleteKey("deleted-key")
+	return []keyvault.DeletedKeyBundle{dk}, err
+}
+
+func (m MockKeyvault) RecoverDeletedKey(key string) (keyvault.KeyBundle, error) {
+	id := fmt.Sprintf("https://%s.%s/keys/%s/%s", m.Name, azure.PublicCloud.KeyVaultDNSSuffix, key, "123456789")
+	return keyvault.KeyBundle{Key: &keyvault.JSONWebKey{Kid: &id}}, nil
+}
+
+func (m MockKeyvault) PurgeDeletedKey(key string) error {
+	return nil
+}
+
+// RestoreSecret - the backup files of the mock keyvault contain the name of the secret,
+// with store the backup contains all versions of the secret
+func (m MockKeyvault) RestoreSecret(file string) (keyvault.SecretBundle, error) {
+	backup, err := os.ReadFile(file)
+	if err != nil {
+		return keyvault.SecretBundle{}, err
+	}
+	if m.Store != nil {
+		return m.Store.Restore(m.Name, backup)
+	}
+	return m.GetSecret(string(backup), "123456")
+}
+
+// RestoreKey - the backup files of the mock keyvault contain the name of the key,
+// the keys listed by the mock keyvault already exist
+func (m MockKeyvault) RestoreKey(file string) (keyvault.KeyBundle, error) {
+	backup, err := os.ReadFile(file)
+	if err != nil {
+		return keyvault.KeyBundle{}, err
+	}
+	for i := 0; i < 5; i++ {
+		if string(backup) == fmt.Sprintf("key-%v", i) {
+			return keyvault.KeyBundle{}, autorest.DetailedError{StatusCode: http.StatusConflict, Message: "Conflict: key already exists"}
+		}
+	}
+	id := fmt.Sprintf("https://%s.%s/keys/%s/%s", m.Name, azure.PublicCloud.KeyVaultDNSSuffix, string(backup), "123456789")
+	return keyvault.KeyBundle{Key: &keyvault.JSONWebKey{Kid: &id}}, nil
+}
+
 func TestNewKeyvaultObjectId(t *testing.T) {
 	assert := assert.New(t)
 
@@ -147,3 +306,94 @@ func TestNewKeyvaultObjectIdNoVersion(t *testing.T) {
 
 	assert.Empty(objectid.GetVersion(), "should be empty")
 }
+
+func TestParseKeyvaultObjectId(t *testing.T) {
+	assert := assert.New(t)
+
+	valid := map[string]ObjectRef{
+		"https://mykeyvault.vault.azure.net/secrets/mysecret/0f219949d08b459b80c7fcdaf2d56abd": {Keyvault: "mykeyvault", Host: "mykeyvault.vault.azure.net", Type: "secrets", Name: "mysecret", Version: "0f219949d08b459b80c7fcdaf2d56abd"},
+		"https://mykeyvault.vault.azure.net/keys/my-key":                                       {Keyvault: "mykeyvault", Host: "mykeyvault.vault.azure.net", Type: "keys", Name: "my-key"},
+		"https://mykeyvault.vault.azure.net/keys/my-key/":                                      {Keyvault: "mykeyvault", Host: "mykeyvault.vault.azure.net", Type: "keys", Name: "my-key"},
+		"https://My-KeyVault.vault.azure.cn/certificates/mycert/v1":                            {Keyvault: "my-keyvault", Host: "my-keyvault.vault.azure.cn", Type: "certificates", Name: "mycert", Version: "v1"},
+	}
+	for id, expected := range valid {
+		ref, err := ParseKeyvaultObjectId(id)
+		assert.Nil(err, "should be nil")
+		assert.Equal(expected, ref, "should be equal")
+	}
+
+	invalid := []string{
+		"",
+		"mykeyvault",
+		"http://mykeyvault.vault.azure.net/secrets/mysecret",
+		"https://mykeyvault.example.com/secrets/mysecret",
+		"https://vault.azure.net/secrets/mysecret",
+		"https://mykeyvault.vault.azure.net:8443/secrets/mysecret",
+		"https://user@mykeyvault.vault.azure.net/secrets/mysecret",
+		"https://mykeyvault.vault.azure.net/secrets/mysecret?version=1",
+		"https://kv.vault.azure.net/secrets/mysecret",
+		"https://my--keyvault.vault.azure.net/secrets/mysecret",
+		"https://mykeyvault.vault.azure.net/secrets",
+		"https://mykeyvault.vault.azure.net/mytype/myname",
+		"https://mykeyvault.vault.azure.net/secrets/my_secret",
+		"https://mykeyvault.vault.azure.net/secrets/my.secret/v1",
+		"https://mykeyvault.vault.azure.net/secrets/mysecret/v-1",
+		"https://mykeyvault.vault.azure.net/secrets/mysecret/v1/extra",
+		"keyvault+secret://mykeyvault.vault.azure.net/secrets/mysecret",
+	}
+	for _, id := range invalid {
+		ref, err := ParseKeyvaultObjectId(id)
+		assert.Error(err, id)
+		assert.Equal(ObjectRef{}, ref, "should be empty")
+	}
+}
+
+func TestKeyvaultObjectId_ParseType(t *testing.T) {
+	assert := assert.New(t)
+
+	objectid := NewKeyvaultObjectId("mykeyvault", "keys", "mykey", "myversion")
+	ref, err := objectid.ParseType("keys")
+	assert.Nil(err, "should be nil")
+	assert.Equal(string(objectid), ref.String(), "should be equal")
+
+	_, err = objectid.ParseType("secrets")
+	assert.Error(err, "should be error")
+}
+
+func TestKeyvaultObjectId_Malformed(t *testing.T) {
+	assert := assert.New(t)
+
+	// getters of malformed ids return empty strings instead of panicking
+	for _, id := range []string{"", "https://mykeyvault.vault.azure.net/", "https://mykeyvault.vault.azure.net/keys", "%zz"} {
+		objectid := KeyvaultObjectId(id)
+		assert.Empty(objectid.GetType(), "should be empty")
+		assert.Empty(objectid.GetName(), "should be empty")
+		assert.Empty(objectid.GetVersion(), "should be empty")
+	}
+}
+
+func FuzzParseKeyvaultObjectId(f *testing.F) {
+	f.Add("https://mykeyvault.vault.azure.net/secrets/mysecret/0f219949d08b459b80c7fcdaf2d56abd")
+	f.Add("https://mykeyvault.vault.azure.net/keys/mykey/")
+	f.Add("https://mykeyvault.vault.azure.net/keys")
+	f.Add("https://mykeyvault.vault.azure.net/certificates/a/b/c")
+
+	f.Fuzz(func(t *testing.T, id string) {
+		objectid := KeyvaultObjectId(id)
+		_, _, _, _ = objectid.GetKeyvault(), objectid.GetType(), objectid.GetName(), objectid.GetVersion()
+
+		ref, err := ParseKeyvaultObjectId(id)
+		if err != nil {
+			return
+		}
+
+		// every accepted id has to be valid when formatted and parsed again
+		reparsed, err := ParseKeyvaultObjectId(ref.String())
+		if err != nil {
+			t.Fatalf("unable to parse formatted id %s: %v", ref.String(), err)
+		}
+		if reparsed != ref {
+			t.Fatalf("roundtrip mismatch %v != %v", reparsed, ref)
+		}
+	})
+}
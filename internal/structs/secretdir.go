@@ -0,0 +1,153 @@
+package structs
+
+import (
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// secretNameFromPath - derive the secret name from the path of the file relative to the secrets directory,
+// the extension is removed and path separators and dots are replaced by dashes, e.g. myapp/db.password.yaml
+// with prefix team- results in team-myapp-db-password
+func secretNameFromPath(prefix string, rel string) (string, error) {
+	name := strings.TrimSuffix(filepath.ToSlash(rel), filepath.Ext(rel))
+	name = prefix + strings.NewReplacer("/", "-", ".", "-").Replace(name)
+	if !objectNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("Unable to derive a secret name from %s, '%s' isnt a valid secret name", rel, name)
+	}
+	return name, nil
+}
+
+// readSecretDirectory - return the secrets of all files in the directory and its subdirectories,
+// hidden files and directories are ignored
+func readSecretDirectory(kv keyvault.KeyvaultInterface, dir string, prefix string) ([]Secret, map[string]string, error) {
+
+	var secrets []Secret
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name, err := secretNameFromPath(prefix, rel)
+		if err != nil {
+			return err
+		}
+		if f, exists := files[name]; exists {
+			return fmt.Errorf("Files %s and %s both result in secret %s", f, rel, name)
+		}
+		files[name] = filepath.ToSlash(rel)
+
+		c, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		s := NewSecret(kv, name, "")
+		s.Encode(string(c))
+		secrets = append(secrets, s)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return secrets, files, nil
+}
+
+// existingContentType - return the content type of the listed secret, the content type of secrets stored in
+// multiple secrets is read from their manifest. Disabled secrets cant be read, they are updated as base64
+func existingContentType(kv keyvault.KeyvaultInterface, s Secret) (string, error) {
+	if s.ContentType != contentTypeManifest {
+		return s.ContentType, nil
+	}
+	if s.disabled() {
+		return ContentTypeBase64, nil
+	}
+	sb, err := kv.GetSecret(s.Name, "")
+	if err != nil {
+		return "", err
+	}
+	return bundleContentType(sb), nil
+}
+
+// PlanSecretDirectory - return the changes required to bring the secrets of the keyvault in line with the files of
+// the directory. Every file becomes a secret named after its path, secrets are compared by the checksum of their
+// content. Updated secrets keep their content type, tags and attributes, disabled secrets stay disabled and secrets
+// managed by certificates are skipped. With prune the secrets with the prefix which dont exist as file are deleted,
+// the prefix is required to keep secrets not managed by the directory
+func PlanSecretDirectory(kv keyvault.KeyvaultInterface, dir string, prefix string, prune bool) ([]SecretChange, error) {
+
+	if prune && prefix == "" {
+		return nil, fmt.Errorf("Pruning requires a prefix, otherwise every secret of the keyvault not in %s is deleted", dir)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s isnt a directory", dir)
+	}
+
+	secrets, files, err := readSecretDirectory(kv, dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	sl := SecretList{}
+	existing, err := sl.selectSecrets(kv, SecretFilter{Prefix: prefix}, true, false)
+	if err != nil {
+		return nil, err
+	}
+	vault := map[string]Secret{}
+	for _, s := range existing {
+		vault[s.Name] = s
+	}
+
+	var changes []SecretChange
+	for _, s := range secrets {
+		change := SecretChange{Action: SecretChangeCreate, Name: s.Name, Source: files[s.Name], secret: s}
+		if v, exists := vault[s.Name]; exists {
+			change.Action = SecretChangeUnchanged
+			if !s.matchesChecksum(v.Tags[TagChecksum]) {
+				change.Action = SecretChangeUpdate
+				// content type, tags and attributes set in the keyvault are kept
+				ct, err := existingContentType(kv, v)
+				if err != nil {
+					return nil, err
+				}
+				change.secret.ContentType = ct
+				change.secret.Encode(string(s.plain()))
+				change.secret.Tags = v.Tags
+				change.secret.Enabled = v.Enabled
+				change.secret.NotBefore = v.NotBefore
+				change.secret.Expires = v.Expires
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	if prune {
+		for _, s := range existing {
+			if _, exists := files[s.Name]; !exists {
+				changes = append(changes, SecretChange{Action: SecretChangeDelete, Name: s.Name, secret: s})
+			}
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,168 @@
+package structs
+
+import (
+	"encoding/base64"
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault/keyvaulttest"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecretNameFromPath(t *testing.T) {
+	assert := assert.New(t)
+
+	name, err := secretNameFromPath("", "values.yaml")
+	assert.Nil(err, "should be nil")
+	assert.Equal("values", name, "should be equal")
+
+	name, err = secretNameFromPath("team-", filepath.Join("myapp", "db.password.txt"))
+	assert.Nil(err, "should be nil")
+	assert.Equal("team-myapp-db-password", name, "should be equal")
+
+	_, err = secretNameFromPath("", "my_values.yaml")
+	assert.Error(err, "should be error")
+}
+
+func TestPlanSecretDirectory(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault", Store: keyvaulttest.NewStore()}
+	dir := t.TempDir()
+	write := func(rel string, content string) {
+		_ = os.MkdirAll(filepath.Dir(filepath.Join(dir, rel)), 0755)
+		_ = os.WriteFile(filepath.Join(dir, rel), []byte(content), 0644)
+	}
+	write("values.yaml", "key: value\n")
+	write("myapp/db-password.txt", "secret")
+	write(".git/config", "ignored")
+	write(".hidden", "ignored")
+
+	changes, err := PlanSecretDirectory(mock, dir, "app-", false)
+	assert.Nil(err, "should be nil")
+	assert.Len(changes, 2, "should ignore hidden files")
+	assert.Equal(SecretChangeCreate, changes[0].Action, "should be equal")
+	assert.Equal("app-myapp-db-password", changes[0].Name, "should be equal")
+	assert.Equal("myapp/db-password.txt", changes[0].Source, "should contain the file")
+	err = ApplySecretChanges(changes)
+	assert.Nil(err, "should be nil")
+
+	s := NewSecret(mock, "app-values", "")
+	s, _ = s.Get()
+	dec, _ := s.Decode()
+	assert.Equal("key: value\n", dec, "should be equal")
+
+	// tags and attributes set in the keyvault are kept on updates
+	expires := JTime(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	s.Tags = map[string]string{"owner": "team-a"}
+	s.Expires = &expires
+	_, _ = s.Put()
+	s = NewSecret(mock, "app-obsolete", "")
+	s.Encode("obsolete")
+	_, _ = s.Put()
+	s = NewSecret(mock, "other", "")
+	s.Encode("other")
+	_, _ = s.Put()
+	write("values.yaml", "key: changed\n")
+
+	changes, err = PlanSecretDirectory(mock, dir, "app-", false)
+	assert.Nil(err, "should be nil")
+	assert.Len(changes, 2, "should not contain deletions")
+	assert.Equal(SecretChangeUnchanged, changes[0].Action, "should be equal")
+	assert.Equal(SecretChangeUpdate, changes[1].Action, "should be equal")
+
+	changes, err = PlanSecretDirectory(mock, dir, "app-", true)
+	assert.Nil(err, "should be nil")
+	assert.Len(changes, 3, "should contain the deletion")
+	assert.Equal(SecretChangeDelete, changes[2].Action, "should be equal")
+	assert.Equal("app-obsolete", changes[2].Name, "should be equal")
+	err = ApplySecretChanges(changes)
+	assert.Nil(err, "should be nil")
+
+	s = NewSecret(mock, "app-values", "")
+	s, _ = s.Get()
+	dec, _ = s.Decode()
+	assert.Equal("key: changed\n", dec, "should be equal")
+	assert.Equal("team-a", s.Tags["owner"], "should be kept")
+	assert.Equal(time.Time(expires).Unix(), time.Time(*s.Expires).Unix(), "should be kept")
+	assert.Len(mock.Store.Secrets["app-obsolete"], 0, "should be deleted")
+	assert.Len(mock.Store.Secrets["other"], 1, "should not be deleted")
+}
+
+func TestPlanSecretDirectory_Existing(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault", Store: keyvaulttest.NewStore()}
+	dir := t.TempDir()
+	for _, n := range []string{"plain", "large", "disabled"} {
+		_ = os.WriteFile(filepath.Join(dir, n+".txt"), []byte("new "+n), 0644)
+	}
+	disabled, managed := false, true
+	plain := NewSecret(mock, "app-plain", "")
+	plain.ContentType = ContentTypePlain
+	plain.Encode("old plain")
+	_, _ = plain.Put()
+	large := NewSecret(mock, "app-large", "")
+	large.ContentType = ContentTypePlain
+	large.Encode(strings.Repeat("x", 2*secretChunkSize))
+	_, _ = large.Put()
+	d := NewSecret(mock, "app-disabled", "")
+	d.Encode("old disabled")
+	d.Enabled = &disabled
+	_, _ = d.Put()
+	value := "certificate"
+	mock.Store.Set("mykeyvault", "app-cert", keyvault.SecretSetParameters{Value: &value})
+	mock.Store.Secrets["app-cert"][0].Managed = &managed
+
+	// managed secrets arent pruned, disabled secrets are updated instead of created
+	changes, err := PlanSecretDirectory(mock, dir, "app-", true)
+	assert.Nil(err, "should be nil")
+	actions := map[string]string{}
+	for _, c := range changes {
+		actions[c.Name] = c.Action
+	}
+	assert.Equal(map[string]string{
+		"app-disabled": SecretChangeUpdate, "app-large": SecretChangeUpdate, "app-plain": SecretChangeUpdate,
+	}, actions, "should be equal")
+
+	// the content type of the existing secrets is kept, disabled secrets stay disabled
+	err = ApplySecretChanges(changes)
+	assert.Nil(err, "should be nil")
+	for _, n := range []string{"app-plain", "app-large"} {
+		s := NewSecret(mock, n, "")
+		s, _ = s.Get()
+		assert.Equal(ContentTypePlain, s.ContentType, "should be kept")
+		assert.Equal("new "+strings.TrimPrefix(n, "app-"), s.Value, "should be equal")
+	}
+	sb, _ := mock.GetSecret("app-disabled", "")
+	assert.False(*sb.Attributes.Enabled, "should stay disabled")
+	assert.Equal(base64.StdEncoding.EncodeToString([]byte("new disabled")), *sb.Value, "should be updated")
+}
+
+func TestPlanSecretDirectory_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault", Store: keyvaulttest.NewStore()}
+	dir := t.TempDir()
+
+	_, err := PlanSecretDirectory(mock, filepath.Join(dir, "missing"), "", false)
+	assert.Error(err, "should be error")
+
+	// pruning without prefix would delete every other secret of the keyvault
+	_, err = PlanSecretDirectory(mock, dir, "", true)
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), "requires a prefix", "should contain the reason")
+
+	_ = os.WriteFile(filepath.Join(dir, "values.yaml"), []byte("a"), 0644)
+	_, err = PlanSecretDirectory(mock, filepath.Join(dir, "values.yaml"), "", false)
+	assert.Error(err, "should be error")
+
+	// both files result in the secret values
+	_ = os.WriteFile(filepath.Join(dir, "values.json"), []byte("b"), 0644)
+	_, err = PlanSecretDirectory(mock, dir, "", false)
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), "both result in secret values", "should contain the conflict")
+}
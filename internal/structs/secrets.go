@@ -2,10 +2,51 @@ package structs
 
 import (
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	mskeyvault "github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/Azure/go-autorest/autorest/date"
 	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
+const (
+	// workers used to retrieve multiple secrets concurrently
+	secretWorkers = 8
+
+	// tag marking a secret as part of a secret stored in multiple secrets
+	TagPartOf = "helm-keyvault-part-of"
+	// tag containing the salted checksum of the plain secret value, see saltedChecksum
+	TagChecksum = "helm-keyvault-checksum"
+
+	// content types of the secrets written by the plugin. Only base64 values are decoded,
+	// values with other content types are used as they are
+	ContentTypeBase64   = "base64"
+	ContentTypePlain    = "text/plain"
+	contentTypeManifest = "application/vnd.helm-keyvault.manifest+json"
+	// keyvault secrets are limited to 25k characters
+	secretChunkSize = 24 * 1024
+)
+
+// SecretManifest - stored instead of the value of secrets exceeding the keyvault size limit,
+// it references the secrets containing the parts of the value
+type SecretManifest struct {
+	Parts    []SecretPart `json:"parts"`
+	Checksum string       `json:"checksum"`
+	// content type of the reassembled value
+	ContentType string `json:"contentType,omitempty"`
+}
+
+type SecretPart struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
 //type SecretInterface interface {
 //	Get() (Secret, error)
 //	Put() (Secret, error)
@@ -30,6 +71,56 @@ type Secret struct {
 	KeyVault keyvault.KeyvaultInterface `json:"keyvault,omitempty"`
 	Version  string                     `json:"version,omitempty"`
 	Value    string                     `json:"value,omitempty"`
+	Tags     map[string]string          `json:"tags,omitempty"`
+
+	ContentType string `json:"contenttype,omitempty"`
+	Enabled     *bool  `json:"enabled,omitempty"`
+	NotBefore   *JTime `json:"notbefore,omitempty"`
+	Expires     *JTime `json:"expires,omitempty"`
+	Created     *JTime `json:"created,omitempty"`
+	Updated     *JTime `json:"updated,omitempty"`
+}
+
+// setAttributes - set the content type, tags and attributes of the secret from the given secret bundle
+func (s *Secret) setAttributes(sb mskeyvault.SecretBundle) {
+	s.ContentType = bundleContentType(sb)
+	s.Tags = convertTags(sb.Tags)
+	if sb.Attributes == nil {
+		return
+	}
+	s.Enabled = sb.Attributes.Enabled
+	if sb.Attributes.NotBefore != nil {
+		nbf := JTime(time.Time(*sb.Attributes.NotBefore))
+		s.NotBefore = &nbf
+	}
+	if sb.Attributes.Expires != nil {
+		exp := JTime(time.Time(*sb.Attributes.Expires))
+		s.Expires = &exp
+	}
+	if sb.Attributes.Created != nil {
+		created := JTime(time.Time(*sb.Attributes.Created))
+		s.Created = &created
+	}
+	if sb.Attributes.Updated != nil {
+		updated := JTime(time.Time(*sb.Attributes.Updated))
+		s.Updated = &updated
+	}
+}
+
+// bundleContentType - return the content type of the secret bundle, for secrets stored in multiple
+// secrets the content type of the reassembled value is returned
+func bundleContentType(sb mskeyvault.SecretBundle) string {
+	if sb.ContentType == nil {
+		return ""
+	}
+	if *sb.ContentType != contentTypeManifest || sb.Value == nil {
+		return *sb.ContentType
+	}
+	var m SecretManifest
+	if json.Unmarshal([]byte(*sb.Value), &m) != nil || m.ContentType == "" {
+		return ContentTypeBase64
+	}
+	return m.ContentType
 }
 
 // Get - retrieve secret from keyvault
@@ -41,33 +132,550 @@ func (s *Secret) Get() (Secret, error) {
 	}
 
 	sid := KeyvaultObjectId(*sb.ID)
+	ref, err := sid.ParseType("secrets")
+	if err != nil {
+		return Secret{}, err
+	}
 
-	return Secret{
+	value := *sb.Value
+	if sb.ContentType != nil && *sb.ContentType == contentTypeManifest {
+		value, err = s.getParts(value)
+		if err != nil {
+			return Secret{}, fmt.Errorf("Unable to retrieve secret %s: %v", ref.Name, err)
+		}
+	}
+
+	sec := Secret{
 		Id:       sid,
-		Name:     sid.GetName(),
+		Name:     ref.Name,
 		KeyVault: s.KeyVault,
-		Version:  sid.GetVersion(),
-		Value:    *sb.Value,
-	}, nil
+		Version:  ref.Version,
+		Value:    value,
+	}
+	sec.setAttributes(sb)
+	return sec, nil
+}
+
+// getParts - retrieve the parts referenced by the manifest and return the reassembled value
+func (s *Secret) getParts(manifest string) (string, error) {
+	var m SecretManifest
+	err := json.Unmarshal([]byte(manifest), &m)
+	if err != nil {
+		return "", err
+	}
+
+	var parts []Secret
+	for _, p := range m.Parts {
+		parts = append(parts, NewSecret(s.KeyVault, p.Name, p.Version))
+	}
+	sl := SecretList{}
+	parts, err = sl.GetAll(parts)
+	if err != nil {
+		return "", err
+	}
+
+	var value strings.Builder
+	for _, p := range parts {
+		value.WriteString(p.Value)
+	}
+	if !verifyChecksum(m.Checksum, []byte(value.String())) {
+		return "", fmt.Errorf("Checksum of the reassembled secret doesnt match")
+	}
+	return value.String(), nil
 }
 
-// Put - put secret into keyvault
+// chunks - return the chunks of a value exceeding the keyvault size limit, nil if the value fits into a single secret
+func (s *Secret) chunks() []string {
+	if len(s.Value) <= secretChunkSize {
+		return nil
+	}
+
+	var chunks []string
+	value := s.Value
+	for len(value) > 0 {
+		chunk := value
+		if len(chunk) > secretChunkSize {
+			// raw values are only split between utf-8 characters
+			end := secretChunkSize
+			for end > 0 && !utf8.RuneStart(value[end]) {
+				end--
+			}
+			chunk = chunk[:end]
+		}
+		value = value[len(chunk):]
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// putParts - store the chunks in multiple secrets and return the manifest referencing them.
+// The part names are validated before anything is written, existing secrets are only overwritten
+// if they are parts of the secret
+func (s *Secret) putParts(chunks []string, ct string) (SecretManifest, error) {
+	checksum, err := saltedChecksum([]byte(s.Value))
+	if err != nil {
+		return SecretManifest{}, err
+	}
+	m := SecretManifest{Checksum: checksum, ContentType: ct}
+
+	sb, err := s.KeyVault.ListSecrets()
+	if err != nil {
+		return SecretManifest{}, err
+	}
+	existing := map[string]*string{}
+	for _, e := range sb {
+		eid := KeyvaultObjectId(*e.ID)
+		existing[eid.GetName()] = e.Tags[TagPartOf]
+	}
+	for n := range chunks {
+		name := s.partName(n + 1)
+		if !objectNameRegexp.MatchString(name) {
+			return SecretManifest{}, fmt.Errorf("Secret %s is too large, the name of its part %s exceeds the keyvault limit of 127 characters", s.Name, name)
+		}
+		if partOf, ok := existing[name]; ok && (partOf == nil || *partOf != s.Name) {
+			return SecretManifest{}, fmt.Errorf("Unable to put part %s of secret %s, a secret with the same name exists and isnt a part of the secret", name, s.Name)
+		}
+	}
+
+	for n, chunk := range chunks {
+		chunk := chunk
+		name := s.partName(n + 1)
+		sb, err := s.KeyVault.SetSecret(name, mskeyvault.SecretSetParameters{
+			Value:       &chunk,
+			ContentType: &ct,
+			Tags:        map[string]*string{TagPartOf: &s.Name},
+		})
+		if err != nil {
+			return SecretManifest{}, err
+		}
+		pid := KeyvaultObjectId(*sb.ID)
+		m.Parts = append(m.Parts, SecretPart{Name: name, Version: pid.GetVersion()})
+	}
+	return m, nil
+}
+
+// disableStaleParts - disable the latest versions of the parts numbered above the given count, e.g. the parts
+// of a former larger value. Parts are numbered consecutively, the first missing or foreign secret ends the search
+func (s *Secret) disableStaleParts(count int) error {
+	for n := count + 1; ; n++ {
+		sb, err := s.KeyVault.GetSecret(s.partName(n), "")
+		if err != nil || sb.Tags[TagPartOf] == nil || *sb.Tags[TagPartOf] != s.Name {
+			return nil
+		}
+		if sb.Attributes != nil && sb.Attributes.Enabled != nil && !*sb.Attributes.Enabled {
+			continue
+		}
+		pid := KeyvaultObjectId(*sb.ID)
+		part := NewSecret(s.KeyVault, s.partName(n), pid.GetVersion())
+		_, err = part.Disable()
+		if err != nil {
+			return fmt.Errorf("Unable to disable stale part %s of secret %s: %v", part.Name, s.Name, err)
+		}
+	}
+}
+
+// partName - return the name of the secret containing the n-th part of the secret
+func (s *Secret) partName(n int) string {
+	return fmt.Sprintf("%s-part-%d", s.Name, n)
+}
+
+// Put - put secret into keyvault with the secrets content type (default base64), tags and attributes.
+// Values exceeding the keyvault size limit are split into multiple secrets named <secret>-part-<n>,
+// the secret itself contains a manifest referencing the parts.
+// A salted checksum of the plain value is added as tag to detect unchanged values without revealing the value
 func (s *Secret) Put() (Secret, error) {
 
-	sb, err := s.KeyVault.PutSecret(s.Name, s.Value)
+	value := s.Value
+	ct := s.contentType()
+	var m SecretManifest
+	if chunks := s.chunks(); chunks != nil {
+		var err error
+		m, err = s.putParts(chunks, ct)
+		if err != nil {
+			return Secret{}, err
+		}
+		j, err := json.Marshal(m)
+		if err != nil {
+			return Secret{}, err
+		}
+		value = string(j)
+		ct = contentTypeManifest
+	}
+
+	tags := map[string]*string{}
+	for k, v := range s.Tags {
+		v := v
+		tags[k] = &v
+	}
+	checksum, err := saltedChecksum(s.plain())
+	if err != nil {
+		return Secret{}, err
+	}
+	tags[TagChecksum] = &checksum
+
+	sb, err := s.KeyVault.SetSecret(s.Name, mskeyvault.SecretSetParameters{
+		Value:            &value,
+		ContentType:      &ct,
+		Tags:             tags,
+		SecretAttributes: s.attributes(),
+	})
+	if err != nil {
+		return Secret{}, err
+	}
+
+	// parts of a former larger value are only disabled once the manifest doesnt reference them anymore
+	err = s.disableStaleParts(len(m.Parts))
 	if err != nil {
 		return Secret{}, err
 	}
 
 	sid := KeyvaultObjectId(*sb.ID)
+	ref, err := sid.ParseType("secrets")
+	if err != nil {
+		return Secret{}, err
+	}
 
-	return Secret{
+	sec := Secret{
 		Id:       sid,
-		Name:     sid.GetName(),
+		Name:     ref.Name,
 		KeyVault: s.KeyVault,
-		Version:  sid.GetVersion(),
-		Value:    *sb.Value,
-	}, nil
+		Version:  ref.Version,
+		Value:    s.Value,
+	}
+	sec.setAttributes(sb)
+	if sec.ContentType == contentTypeManifest {
+		sec.ContentType = s.contentType()
+	}
+	return sec, nil
+}
+
+// contentType - return the content type of the secret, secrets without content type are base64 encoded
+func (s *Secret) contentType() string {
+	if s.ContentType == "" {
+		return ContentTypeBase64
+	}
+	return s.ContentType
+}
+
+// attributes - return the keyvault attributes of the secret, nil if no attribute is set
+func (s *Secret) attributes() *mskeyvault.SecretAttributes {
+	if s.Enabled == nil && s.NotBefore == nil && s.Expires == nil {
+		return nil
+	}
+	attr := &mskeyvault.SecretAttributes{Enabled: s.Enabled}
+	if s.NotBefore != nil {
+		nbf := date.UnixTime(time.Time(*s.NotBefore))
+		attr.NotBefore = &nbf
+	}
+	if s.Expires != nil {
+		exp := date.UnixTime(time.Time(*s.Expires))
+		attr.Expires = &exp
+	}
+	return attr
+}
+
+// plain - return the plain (decoded) value of the secret to put
+func (s *Secret) plain() []byte {
+	if s.contentType() == ContentTypeBase64 {
+		dec, err := base64.StdEncoding.DecodeString(s.Value)
+		if err == nil {
+			return dec
+		}
+	}
+	return []byte(s.Value)
+}
+
+// disabled - returns true if the secret is disabled
+func (s *Secret) disabled() bool {
+	return s.Enabled != nil && !*s.Enabled
+}
+
+// matchesChecksum - returns true if the salted checksum of a secret version matches the secret value
+func (s *Secret) matchesChecksum(checksum string) bool {
+	return verifyChecksum(checksum, s.plain())
+}
+
+// Unchanged - returns true if the checksum of the latest version of the secret matches the secret value
+// and the same content type, tags and attributes as the secret
+func (s *Secret) Unchanged() bool {
+	sb, err := s.KeyVault.GetSecret(s.Name, "")
+	if err != nil || sb.Tags[TagChecksum] == nil || !s.matchesChecksum(*sb.Tags[TagChecksum]) {
+		return false
+	}
+
+	latest := Secret{}
+	latest.setAttributes(sb)
+	if latest.ContentType != s.contentType() {
+		return false
+	}
+	// the checksum tag is replaced on every put
+	for _, tags := range []map[string]string{s.Tags, latest.Tags} {
+		for k := range tags {
+			if k == TagChecksum {
+				continue
+			}
+			lv, lok := latest.Tags[k]
+			v, ok := s.Tags[k]
+			if !lok || !ok || lv != v {
+				return false
+			}
+		}
+	}
+
+	enabled := func(e *bool) bool { return e == nil || *e }
+	unix := func(t *JTime) int64 {
+		if t == nil {
+			return 0
+		}
+		return time.Time(*t).Unix()
+	}
+	return enabled(latest.Enabled) == enabled(s.Enabled) &&
+		unix(latest.NotBefore) == unix(s.NotBefore) &&
+		unix(latest.Expires) == unix(s.Expires)
+}
+
+// ParseTags - parse tags in the form "name=value". Tag filters additionally accept "name:value" and
+// "name" without value, which matches every value of the tag
+func ParseTags(tags []string, filter bool) (map[string]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	parsed := map[string]string{}
+	for _, t := range tags {
+		kv := strings.SplitN(t, "=", 2)
+		if len(kv) == 1 && filter {
+			kv = strings.SplitN(t, ":", 2)
+		}
+		if kv[0] == "" || (len(kv) == 1 && !filter) {
+			if filter {
+				return nil, fmt.Errorf("Invalid tag filter '%s', expected name, name=value or name:value", t)
+			}
+			return nil, fmt.Errorf("Invalid tag '%s', expected name=value", t)
+		}
+		parsed[kv[0]] = ""
+		if len(kv) == 2 {
+			parsed[kv[0]] = kv[1]
+		}
+	}
+	return parsed, nil
+}
+
+// PutIfLatest - put secret into keyvault if the latest version of the secret is still the given version.
+// keyvault doesnt support conditional updates, the check narrows the window for lost updates to the
+// time between the version check and the put request
+func (s *Secret) PutIfLatest(version string) (Secret, error) {
+
+	sb, err := s.KeyVault.GetSecret(s.Name, "")
+	if err != nil {
+		return Secret{}, err
+	}
+	lid := KeyvaultObjectId(*sb.ID)
+	latest := lid.GetVersion()
+	if latest != version {
+		return Secret{}, fmt.Errorf("Secret %s was updated concurrently (expected version %s, found %s)", s.Name, version, latest)
+	}
+
+	return s.Put()
+}
+
+// Versions - list all versions of the secret without values and checksums, the latest version first
+func (s *Secret) Versions() ([]Secret, error) {
+
+	sb, err := s.KeyVault.ListSecretVersions(s.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Secret
+	for _, v := range sb {
+		soid := KeyvaultObjectId(*v.ID)
+		ref, err := soid.ParseType("secrets")
+		if err != nil {
+			return nil, err
+		}
+		sec := Secret{
+			Id:       soid,
+			Name:     ref.Name,
+			KeyVault: s.KeyVault,
+			Version:  ref.Version,
+		}
+		sec.setAttributes(v)
+		// the checksum is salted on every put and doesnt allow comparing versions
+		delete(sec.Tags, TagChecksum)
+		versions = append(versions, sec)
+	}
+
+	created := func(sec Secret) time.Time {
+		if sec.Created == nil {
+			return time.Time{}
+		}
+		return time.Time(*sec.Created)
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return created(versions[i]).After(created(versions[j]))
+	})
+	return versions, nil
+}
+
+// Rollback - put the value, content type and tags of the given version as new version of the secret
+func (s *Secret) Rollback(version string) (Secret, error) {
+
+	old := NewSecret(s.KeyVault, s.Name, version)
+	old, err := old.Get()
+	if err != nil {
+		return Secret{}, err
+	}
+
+	sec := NewSecret(s.KeyVault, s.Name, "")
+	sec.Value = old.Value
+	sec.Tags = old.Tags
+	sec.NotBefore = old.NotBefore
+	sec.Expires = old.Expires
+	// values without content type are raw values
+	sec.ContentType = old.ContentType
+	if sec.ContentType == "" {
+		sec.ContentType = ContentTypePlain
+	}
+	return sec.Put()
+}
+
+// Disable - disable the version of the secret, disabled versions cant be retrieved anymore
+func (s *Secret) Disable() (Secret, error) {
+	if s.Version == "" {
+		return Secret{}, fmt.Errorf("Secret %s requires a version to disable", s.Name)
+	}
+
+	enabled := false
+	sb, err := s.KeyVault.UpdateSecret(s.Name, s.Version, mskeyvault.SecretUpdateParameters{
+		SecretAttributes: &mskeyvault.SecretAttributes{Enabled: &enabled},
+	})
+	if err != nil {
+		return Secret{}, err
+	}
+
+	sid := KeyvaultObjectId(*sb.ID)
+	ref, err := sid.ParseType("secrets")
+	if err != nil {
+		return Secret{}, err
+	}
+	sec := Secret{
+		Id:       sid,
+		Name:     ref.Name,
+		KeyVault: s.KeyVault,
+		Version:  ref.Version,
+	}
+	sec.setAttributes(sb)
+	return sec, nil
+}
+
+// parts - return the names of the secrets containing the parts of the secret
+func (s *Secret) parts() ([]string, error) {
+	sb, err := s.KeyVault.ListSecrets()
+	if err != nil {
+		return nil, err
+	}
+	var parts []string
+	for _, p := range sb {
+		if p.Tags[TagPartOf] == nil || *p.Tags[TagPartOf] != s.Name {
+			continue
+		}
+		pid := KeyvaultObjectId(*p.ID)
+		parts = append(parts, pid.GetName())
+	}
+	return parts, nil
+}
+
+// deletedParts - return the names of the deleted secrets containing the parts of the secret
+func (s *Secret) deletedParts() ([]string, error) {
+	ds, err := s.KeyVault.ListDeletedSecrets()
+	if err != nil {
+		return nil, err
+	}
+	var parts []string
+	for _, p := range ds {
+		if p.Tags[TagPartOf] == nil || *p.Tags[TagPartOf] != s.Name {
+			continue
+		}
+		pid := KeyvaultObjectId(*p.ID)
+		parts = append(parts, pid.GetName())
+	}
+	return parts, nil
+}
+
+// Delete - delete all versions of the secret including the secrets containing its parts
+func (s *Secret) Delete() (DeletedObject, error) {
+	parts, err := s.parts()
+	if err != nil {
+		return DeletedObject{}, err
+	}
+
+	// the secret is deleted first, it isnt listed with missing parts
+	d, err := s.KeyVault.DeleteSecret(s.Name)
+	if err != nil {
+		return DeletedObject{}, err
+	}
+	for _, p := range parts {
+		_, err = s.KeyVault.DeleteSecret(p)
+		if err != nil {
+			return DeletedObject{}, fmt.Errorf("Unable to delete part %s of secret %s: %v", p, s.Name, err)
+		}
+	}
+
+	return newDeletedObject("secrets", d.ID, d.RecoveryID, d.DeletedDate, d.ScheduledPurgeDate)
+}
+
+// Recover - recover the deleted secret including the secrets containing its parts
+func (s *Secret) Recover() (Secret, error) {
+	parts, err := s.deletedParts()
+	if err != nil {
+		return Secret{}, err
+	}
+
+	// the parts are recovered first, the secret is only listed once all parts exist
+	for _, p := range parts {
+		_, err = s.KeyVault.RecoverDeletedSecret(p)
+		if err != nil {
+			return Secret{}, fmt.Errorf("Unable to recover part %s of secret %s: %v", p, s.Name, err)
+		}
+	}
+	sb, err := s.KeyVault.RecoverDeletedSecret(s.Name)
+	if err != nil {
+		return Secret{}, err
+	}
+
+	sid := KeyvaultObjectId(*sb.ID)
+	ref, err := sid.ParseType("secrets")
+	if err != nil {
+		return Secret{}, err
+	}
+	sec := Secret{
+		Id:       sid,
+		Name:     ref.Name,
+		KeyVault: s.KeyVault,
+		Version:  ref.Version,
+	}
+	sec.setAttributes(sb)
+	return sec, nil
+}
+
+// Purge - permanently delete the deleted secret including the secrets containing its parts
+func (s *Secret) Purge() error {
+	parts, err := s.deletedParts()
+	if err != nil {
+		return err
+	}
+
+	err = s.KeyVault.PurgeDeletedSecret(s.Name)
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		err = s.KeyVault.PurgeDeletedSecret(p)
+		if err != nil {
+			return fmt.Errorf("Unable to purge part %s of secret %s: %v", p, s.Name, err)
+		}
+	}
+	return nil
 }
 
 // Backup - create backup of secret and write it into the given file
@@ -90,9 +698,45 @@ func (s *Secret) Backup(f string) error {
 	return nil
 }
 
-// Decode - decode the given value from base64 to string
+// RestoreSecret - restore the secret from the given backup file into the keyvault. Backups of secrets
+// split into multiple parts dont contain the parts, the restore fails if the parts dont exist
+func RestoreSecret(kv keyvault.KeyvaultInterface, f string) (Secret, error) {
+	sb, err := kv.RestoreSecret(f)
+	if err != nil {
+		return Secret{}, restoreError("secret", kv.GetKeyvaultName(), err)
+	}
+
+	sid := KeyvaultObjectId(*sb.ID)
+	ref, err := sid.ParseType("secrets")
+	if err != nil {
+		return Secret{}, err
+	}
+	sec := Secret{
+		Id:       sid,
+		Name:     ref.Name,
+		KeyVault: kv,
+		Version:  ref.Version,
+	}
+	sec.setAttributes(sb)
+
+	if sb.ContentType != nil && *sb.ContentType == contentTypeManifest {
+		full := NewSecret(kv, ref.Name, ref.Version)
+		_, err := full.Get()
+		if err != nil {
+			return Secret{}, fmt.Errorf("Secret %s was restored into keyvault %s without its parts: %v. Secrets split into multiple parts are restored with their parts from vault backup archives (helm keyvault backup/restore)", ref.Name, kv.GetKeyvaultName(), err)
+		}
+	}
+	return sec, nil
+}
+
+// Decode - decode the value of the secret. Only secrets with the content type base64 are decoded,
+// values with other or without content type (e.g. created in the azure portal) are returned as they are
 func (s *Secret) Decode() (string, error) {
 
+	if s.ContentType != ContentTypeBase64 {
+		return s.Value, nil
+	}
+
 	dec, err := base64.StdEncoding.DecodeString(s.Value)
 	if err != nil {
 		return "", err
@@ -101,6 +745,16 @@ func (s *Secret) Decode() (string, error) {
 	return string(dec), nil
 }
 
+// Encode - set the value of the secret to put, the value is base64 encoded if the secret
+// has the content type base64 or no content type
+func (s *Secret) Encode(plain string) {
+	if s.contentType() == ContentTypeBase64 {
+		s.Value = base64.StdEncoding.EncodeToString([]byte(plain))
+		return
+	}
+	s.Value = plain
+}
+
 type SecretList struct {
 	Secrets []Secret `json:"secrets,omitempty"`
 }
@@ -114,17 +768,165 @@ func (sl *SecretList) List(kv keyvault.KeyvaultInterface) ([]Secret, error) {
 
 	var secrets []Secret
 	for _, s := range sb {
+		// parts of secrets exceeding the size limit are hidden
+		if s.Tags[TagPartOf] != nil {
+			continue
+		}
+
 		soid := KeyvaultObjectId(*s.ID)
-		secrets = append(secrets,
-			Secret{
-				Id:       soid,
-				Name:     soid.GetName(),
-				KeyVault: kv,
-				Version:  soid.GetVersion(),
-				// lets not add the secrets value to the list
-				//Value:    *s.Value,
-			})
+		ref, err := soid.ParseType("secrets")
+		if err != nil {
+			return nil, err
+		}
+		sec := Secret{
+			Id:       soid,
+			Name:     ref.Name,
+			KeyVault: kv,
+			Version:  ref.Version,
+			// lets not add the secrets value to the list
+			//Value:    *s.Value,
+		}
+		sec.setAttributes(s)
+		secrets = append(secrets, sec)
 	}
 
 	return secrets, nil
 }
+
+// Deleted - list all deleted secrets of a keyvault with soft-delete enabled
+func (sl *SecretList) Deleted(kv keyvault.KeyvaultInterface) ([]DeletedObject, error) {
+
+	ds, err := kv.ListDeletedSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []DeletedObject
+	for _, s := range ds {
+		// parts of secrets exceeding the size limit are hidden
+		if s.Tags[TagPartOf] != nil {
+			continue
+		}
+		d, err := newDeletedObject("secrets", s.ID, s.RecoveryID, s.DeletedDate, s.ScheduledPurgeDate)
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, d)
+	}
+	return deleted, nil
+}
+
+// Select - list all enabled secrets matching the given filter
+func (sl *SecretList) Select(kv keyvault.KeyvaultInterface, filter SecretFilter) ([]Secret, error) {
+	return sl.selectSecrets(kv, filter, false, true)
+}
+
+// selectSecrets - list the secrets matching the given filter, disabled secrets and secrets managed
+// by certificates are only included if requested
+func (sl *SecretList) selectSecrets(kv keyvault.KeyvaultInterface, filter SecretFilter, disabled bool, managed bool) ([]Secret, error) {
+
+	sb, err := kv.ListSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets []Secret
+	for _, s := range sb {
+		// disabled secrets cant be retrieved
+		if !disabled && s.Attributes != nil && s.Attributes.Enabled != nil && !*s.Attributes.Enabled {
+			continue
+		}
+		if !managed && isManaged(s.Managed) {
+			continue
+		}
+
+		soid := KeyvaultObjectId(*s.ID)
+		ref, err := soid.ParseType("secrets")
+		if err != nil {
+			return nil, err
+		}
+		tags := convertTags(s.Tags)
+		// helm charts stored in the keyvault arent values
+		if _, chart := tags[ChartTagName]; chart {
+			continue
+		}
+		if _, part := tags[TagPartOf]; part {
+			continue
+		}
+		if !filter.Match(ref.Name, tags) {
+			continue
+		}
+
+		secrets = append(secrets, NewSecret(kv, ref.Name, ""))
+		secrets[len(secrets)-1].setAttributes(s)
+	}
+
+	return secrets, nil
+}
+
+// GetAll - retrieve the given secrets concurrently, the order of the secrets is kept
+func (sl *SecretList) GetAll(secrets []Secret) ([]Secret, error) {
+
+	result := make([]Secret, len(secrets))
+	errs := make([]error, len(secrets))
+
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for w := 0; w < secretWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				result[i], errs[i] = secrets[i].Get()
+			}
+		}()
+	}
+	for i := range secrets {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("Unable to retrieve secret %s: %v", secrets[i].Name, err)
+		}
+	}
+	return result, nil
+}
+
+// SecretFilter - select secrets by name prefix and tags
+type SecretFilter struct {
+	Prefix string
+	// tags the secret needs to have, an empty value only requires the tag to exist
+	Tags map[string]string
+}
+
+// Match - returns true if the given secret name and tags match the filter
+func (f SecretFilter) Match(name string, tags map[string]string) bool {
+	if !strings.HasPrefix(name, f.Prefix) {
+		return false
+	}
+	for k, v := range f.Tags {
+		tv, exists := tags[k]
+		if !exists || (v != "" && tv != v) {
+			return false
+		}
+	}
+	return true
+}
+
+// convertTags - convert the keyvault tags into a string map
+func convertTags(tags map[string]*string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	converted := map[string]string{}
+	for k, v := range tags {
+		converted[k] = ""
+		if v != nil {
+			converted[k] = *v
+		}
+	}
+	return converted
+}
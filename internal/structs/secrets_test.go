@@ -1,13 +1,23 @@
 package structs
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
 	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault/keyvaulttest"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
 func TestNewSecret(t *testing.T) {
@@ -101,4 +111,465 @@ func TestSecretList_List(t *testing.T) {
 	sl.Secrets, _ = sl.List(&mock)
 
 	assert.Len(sl.Secrets, 5, "should be 5")
+	assert.Equal(map[string]string{"env": "prod"}, sl.Secrets[0].Tags, "should be equal")
+}
+
+func TestSecretList_Select(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault"}
+	sl := SecretList{}
+
+	// disabled secrets are skipped
+	secrets, err := sl.Select(&mock, SecretFilter{Prefix: "secret-"})
+	assert.Nil(err, "should be nil")
+	assert.Len(secrets, 4, "should be 4")
+
+	secrets, err = sl.Select(&mock, SecretFilter{Tags: map[string]string{"env": "prod"}})
+	assert.Nil(err, "should be nil")
+	assert.Len(secrets, 2, "should be 2")
+	assert.Equal("secret-0", secrets[0].Name, "should be equal")
+	assert.Equal("secret-2", secrets[1].Name, "should be equal")
+
+	secrets, err = sl.Select(&mock, SecretFilter{Prefix: "secret-1"})
+	assert.Nil(err, "should be nil")
+	assert.Len(secrets, 1, "should be 1")
+
+	secrets, err = sl.Select(&mock, SecretFilter{Prefix: "other-"})
+	assert.Nil(err, "should be nil")
+	assert.Empty(secrets, "should be empty")
+}
+
+func TestSecretList_GetAll(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault"}
+	sl := SecretList{}
+
+	var secrets []Secret
+	for i := 0; i < 20; i++ {
+		secrets = append(secrets, NewSecret(mock, fmt.Sprintf("secret-%v", i), ""))
+	}
+	secrets, err := sl.GetAll(secrets)
+	assert.Nil(err, "should be nil")
+	assert.Len(secrets, 20, "should be 20")
+	for i, s := range secrets {
+		assert.Equal(fmt.Sprintf("secret-%v", i), s.Name, "order should be kept")
+		assert.Equal("My little secret!", s.Value, "should be equal")
+	}
+}
+
+func TestSecretFilter_Match(t *testing.T) {
+	assert := assert.New(t)
+
+	f := SecretFilter{Prefix: "myapp-", Tags: map[string]string{"env": "prod", "managed": ""}}
+	assert.True(f.Match("myapp-db-password", map[string]string{"env": "prod", "managed": "yes"}))
+	assert.False(f.Match("other-db-password", map[string]string{"env": "prod", "managed": "yes"}))
+	assert.False(f.Match("myapp-db-password", map[string]string{"env": "dev", "managed": "yes"}))
+	assert.False(f.Match("myapp-db-password", map[string]string{"env": "prod"}))
+	assert.True(SecretFilter{}.Match("any", nil))
+}
+
+func TestSecret_PutIfLatest(t *testing.T) {
+	assert := assert.New(t)
+
+	// the mock keyvault returns the requested version, the latest version is ""
+	mock := MockKeyvault{Name: "mykeyvault"}
+	secret := NewSecret(mock, "mysecret", "")
+	secret.Value = "My little secret!"
+
+	s, err := secret.PutIfLatest("")
+	assert.Nil(err, "should be nil")
+	assert.Equal("myversion", s.Version, "should be equal")
+
+	s, err = secret.PutIfLatest("outdated")
+	assert.Error(err, "should be error")
+	assert.Empty(s.Id, "should be empty")
+}
+
+func TestSecret_PutChunked(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault", Store: keyvaulttest.NewStore()}
+	value := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("0123456789", 6000)))
+
+	secret := NewSecret(mock, "mysecret", "")
+	secret.Value = value
+	s, err := secret.Put()
+	assert.Nil(err, "should be nil")
+	assert.Equal(value, s.Value, "should be equal")
+	assert.Equal("version1", s.Version, "should be equal")
+
+	// the value is split into parts, the secret contains the manifest
+	assert.Len(mock.Store.Secrets, 5, "should be 5")
+	sb, _ := mock.GetSecret("mysecret", "")
+	assert.Equal(contentTypeManifest, *sb.ContentType, "should be equal")
+	var m SecretManifest
+	_ = json.Unmarshal([]byte(*sb.Value), &m)
+	assert.True(strings.HasPrefix(m.Checksum, "hmac-sha256:"), "should be salted")
+	for _, p := range []string{"mysecret-part-1", "mysecret-part-2", "mysecret-part-3", "mysecret-part-4"} {
+		sb, err := mock.GetSecret(p, "")
+		assert.Nil(err, "should be nil")
+		assert.LessOrEqual(len(*sb.Value), secretChunkSize, "should fit into a secret")
+	}
+
+	s, err = secret.Get()
+	assert.Nil(err, "should be nil")
+	assert.Equal(value, s.Value, "should be equal")
+	dec, _ := s.Decode()
+	assert.Equal(strings.Repeat("0123456789", 6000), dec, "should be equal")
+
+	// the parts arent listed
+	sl := SecretList{}
+	sl.Secrets, err = sl.List(mock)
+	assert.Nil(err, "should be nil")
+	assert.Len(sl.Secrets, 1, "should be 1")
+	assert.Equal("mysecret", sl.Secrets[0].Name, "should be equal")
+
+	// the manifest references the part versions, later writes of the parts, e.g. by a concurrent put, are ignored
+	small := "c21hbGw="
+	_, _ = mock.SetSecret("mysecret-part-2", keyvault.SecretSetParameters{Value: &small})
+	s, err = secret.Get()
+	assert.Nil(err, "should be nil")
+	assert.Equal(value, s.Value, "should be equal")
+
+	manifest := `{"parts": [{"name": "mysecret-part-2", "version": "version2"}], "checksum": "hmac-sha256:00:invalid"}`
+	ct := contentTypeManifest
+	_, _ = mock.SetSecret("mysecret", keyvault.SecretSetParameters{Value: &manifest, ContentType: &ct})
+	_, err = secret.Get()
+	assert.Error(err, "should be error")
+}
+
+func TestSecret_PutChunkedStaleParts(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault", Store: keyvaulttest.NewStore()}
+	enabled := func(name string) bool {
+		sb, _ := mock.GetSecret(name, "")
+		return *sb.Attributes.Enabled
+	}
+
+	secret := NewSecret(mock, "mysecret", "")
+	secret.Value = strings.Repeat("0", 4*secretChunkSize)
+	_, err := secret.Put()
+	assert.Nil(err, "should be nil")
+
+	// the parts of the larger value are disabled once the manifest doesnt reference them anymore
+	secret.Value = strings.Repeat("1", 2*secretChunkSize)
+	_, err = secret.Put()
+	assert.Nil(err, "should be nil")
+	assert.True(enabled("mysecret-part-2"), "should be enabled")
+	assert.False(enabled("mysecret-part-3"), "should be disabled")
+	assert.False(enabled("mysecret-part-4"), "should be disabled")
+
+	secret.Value = "small"
+	_, err = secret.Put()
+	assert.Nil(err, "should be nil")
+	assert.False(enabled("mysecret-part-1"), "should be disabled")
+	assert.False(enabled("mysecret-part-2"), "should be disabled")
+	s, err := secret.Get()
+	assert.Nil(err, "should be nil")
+	assert.Equal("small", s.Value, "should be equal")
+}
+
+func TestSecret_PutChunkedInvalidParts(t *testing.T) {
+	assert := assert.New(t)
+
+	// part names exceeding the name limit are detected before anything is written
+	mock := MockKeyvault{Name: "mykeyvault", Store: keyvaulttest.NewStore()}
+	secret := NewSecret(mock, strings.Repeat("a", 125), "")
+	secret.Value = strings.Repeat("0", 2*secretChunkSize)
+	_, err := secret.Put()
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), "127 characters", "should contain the reason")
+	assert.Empty(mock.Store.Secrets, "should be empty")
+
+	// existing secrets which arent parts of the secret arent overwritten
+	other := "other"
+	_, _ = mock.SetSecret("mysecret-part-2", keyvault.SecretSetParameters{Value: &other})
+	secret = NewSecret(mock, "mysecret", "")
+	secret.Value = strings.Repeat("0", 2*secretChunkSize)
+	_, err = secret.Put()
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), "isnt a part of the secret", "should contain the reason")
+	assert.Len(mock.Store.Secrets, 1, "should be 1")
+	sb, _ := mock.GetSecret("mysecret-part-2", "")
+	assert.Equal("other", *sb.Value, "should be equal")
+
+	// a small value doesnt disable the foreign secret
+	secret.Value = "small"
+	_, err = secret.Put()
+	assert.Nil(err, "should be nil")
+	sb, _ = mock.GetSecret("mysecret-part-2", "")
+	assert.True(*sb.Attributes.Enabled, "should be enabled")
+}
+
+func TestSecret_PutAttributes(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault", Store: keyvaulttest.NewStore()}
+	enabled := false
+	expires := JTime(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	notBefore := JTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	secret := NewSecret(mock, "mysecret", "")
+	secret.Value = base64.StdEncoding.EncodeToString([]byte("My little secret!"))
+	secret.Tags = map[string]string{"owner": "team-a"}
+	secret.ContentType = "text/plain"
+	secret.Enabled = &enabled
+	secret.Expires = &expires
+	secret.NotBefore = &notBefore
+	_, err := secret.Put()
+	assert.Nil(err, "should be nil")
+
+	latest := NewSecret(mock, "mysecret", "")
+	s, err := latest.Get()
+	assert.Nil(err, "should be nil")
+	assert.Equal("text/plain", s.ContentType, "should be equal")
+	assert.Equal("team-a", s.Tags["owner"], "should be equal")
+	// the checksum is salted, the tag doesnt reveal the sha256 of the value
+	assert.True(strings.HasPrefix(s.Tags[TagChecksum], "hmac-sha256:"), "should contain the checksum")
+	plain := sha256.Sum256([]byte("My little secret!"))
+	assert.NotContains(s.Tags[TagChecksum], hex.EncodeToString(plain[:]), "should be salted")
+	assert.False(*s.Enabled, "should be disabled")
+	assert.Equal(expires.String(), s.Expires.String(), "should be equal")
+	assert.Equal(notBefore.String(), s.NotBefore.String(), "should be equal")
+
+	// the attributes are listed
+	sl := SecretList{}
+	sl.Secrets, err = sl.List(mock)
+	assert.Nil(err, "should be nil")
+	assert.Equal("text/plain", sl.Secrets[0].ContentType, "should be equal")
+	assert.Equal(expires.String(), sl.Secrets[0].Expires.String(), "should be equal")
+
+	// changed tags or attributes arent unchanged
+	assert.True(secret.Unchanged(), "should be unchanged")
+	secret.Tags = map[string]string{"owner": "team-b"}
+	assert.False(secret.Unchanged(), "should be changed")
+	secret.Tags = map[string]string{"owner": "team-a"}
+	secret.Expires = nil
+	assert.False(secret.Unchanged(), "should be changed")
+}
+
+func TestParseTags(t *testing.T) {
+	assert := assert.New(t)
+
+	tags, err := ParseTags([]string{"owner=team-a", "url=https://example.com/?a=b"}, false)
+	assert.Nil(err, "should be nil")
+	assert.Equal(map[string]string{"owner": "team-a", "url": "https://example.com/?a=b"}, tags)
+
+	_, err = ParseTags([]string{"owner"}, false)
+	assert.Error(err, "should be error")
+	_, err = ParseTags([]string{"=value"}, false)
+	assert.Error(err, "should be error")
+
+	// filters accept tags without value
+	tags, err = ParseTags([]string{"env:prod", "team=platform", "managed", "url=https://example.com"}, true)
+	assert.Nil(err, "should be nil")
+	assert.Equal(map[string]string{"env": "prod", "team": "platform", "managed": "", "url": "https://example.com"}, tags)
+
+	_, err = ParseTags([]string{"=value"}, true)
+	assert.Error(err, "should be error")
+}
+
+func TestSecret_DecodeRaw(t *testing.T) {
+	assert := assert.New(t)
+
+	// secrets created by other tools dont have a content type
+	mock := MockKeyvault{Name: "mykeyvault", Store: keyvaulttest.NewStore()}
+	value := "My little secret!"
+	_, _ = mock.SetSecret("portal", keyvault.SecretSetParameters{Value: &value})
+
+	secret := NewSecret(mock, "portal", "")
+	s, err := secret.Get()
+	assert.Nil(err, "should be nil")
+	dec, err := s.Decode()
+	assert.Nil(err, "should be nil")
+	assert.Equal(value, dec, "should be equal")
+
+	// raw values are put as they are
+	secret = NewSecret(mock, "raw", "")
+	secret.ContentType = ContentTypePlain
+	secret.Encode(value)
+	assert.Equal(value, secret.Value, "should be equal")
+	s, err = secret.Put()
+	assert.Nil(err, "should be nil")
+	assert.Equal(ContentTypePlain, s.ContentType, "should be equal")
+	dec, _ = s.Decode()
+	assert.Equal(value, dec, "should be equal")
+
+	// raw values are split between utf-8 characters
+	long := strings.Repeat("ä", secretChunkSize)
+	secret = NewSecret(mock, "long", "")
+	secret.ContentType = ContentTypePlain
+	secret.Encode(long)
+	_, err = secret.Put()
+	assert.Nil(err, "should be nil")
+	sb, _ := mock.GetSecret("long-part-1", "")
+	assert.True(utf8.ValidString(*sb.Value), "should be valid utf-8")
+	s, err = secret.Get()
+	assert.Nil(err, "should be nil")
+	assert.Equal(ContentTypePlain, s.ContentType, "should be equal")
+	dec, _ = s.Decode()
+	assert.Equal(long, dec, "should be equal")
+}
+
+func TestSecret_Versions(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault", Store: keyvaulttest.NewStore()}
+	expires := JTime(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	for _, v := range []string{"first", "second", "third"} {
+		secret := NewSecret(mock, "mysecret", "")
+		secret.Tags = map[string]string{"value": v}
+		if v == "first" {
+			secret.Expires = &expires
+		}
+		secret.Encode(v)
+		_, _ = secret.Put()
+	}
+	// the mock creates all versions at the same time
+	for i, v := range mock.Store.Secrets["mysecret"] {
+		created := date.UnixTime(time.Unix(int64(1600000000+i), 0))
+		v.Attributes.Created = &created
+	}
+
+	secret := NewSecret(mock, "mysecret", "")
+	versions, err := secret.Versions()
+	assert.Nil(err, "should be nil")
+	assert.Len(versions, 3, "should be 3")
+	assert.Equal("version3", versions[0].Version, "should be the latest version")
+	assert.Equal("third", versions[0].Tags["value"], "should be equal")
+	assert.NotContains(versions[0].Tags, TagChecksum, "should not contain the checksum")
+	assert.Empty(versions[0].Value, "should be empty")
+
+	// the rollback puts the value, tags and dates of the version as new version
+	s, err := secret.Rollback("version1")
+	assert.Nil(err, "should be nil")
+	assert.Equal("version4", s.Version, "should be equal")
+	s, _ = secret.Get()
+	dec, _ := s.Decode()
+	assert.Equal("first", dec, "should be equal")
+	assert.Equal("first", s.Tags["value"], "should be equal")
+	assert.Equal(time.Time(expires).Unix(), time.Time(*s.Expires).Unix(), "should keep the expiry")
+
+	_, err = secret.Rollback("version9")
+	assert.Error(err, "should be error")
+
+	// disable a version
+	_, err = secret.Disable()
+	assert.Error(err, "should require a version")
+	leaked := NewSecret(mock, "mysecret", "version2")
+	s, err = leaked.Disable()
+	assert.Nil(err, "should be nil")
+	assert.False(*s.Enabled, "should be disabled")
+	sb, _ := mock.GetSecret("mysecret", "version2")
+	assert.False(*sb.Attributes.Enabled, "should be disabled")
+	sb, _ = mock.GetSecret("mysecret", "version1")
+	assert.True(*sb.Attributes.Enabled, "should be enabled")
+}
+
+func TestSecret_DeleteRecoverPurge(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault", Store: keyvaulttest.NewStore()}
+	secret := NewSecret(mock, "mysecret", "")
+	secret.Value = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("0123456789", 6000)))
+	_, _ = secret.Put()
+	other := NewSecret(mock, "other", "")
+	other.Encode("other")
+	_, _ = other.Put()
+
+	// the secret is deleted with its parts
+	d, err := secret.Delete()
+	assert.Nil(err, "should be nil")
+	assert.Equal("mysecret", d.Name, "should be equal")
+	assert.True(d.Recoverable(), "should be recoverable")
+	assert.NotNil(d.ScheduledPurge, "should have a purge date")
+	assert.Len(mock.Store.Secrets, 1, "should only contain the other secret")
+
+	sl := SecretList{}
+	deleted, err := sl.Deleted(mock)
+	assert.Nil(err, "should be nil")
+	assert.Len(deleted, 1, "should hide the parts")
+	assert.Equal("mysecret", deleted[0].Name, "should be equal")
+
+	// the secret is recovered with its parts
+	s, err := secret.Recover()
+	assert.Nil(err, "should be nil")
+	assert.Equal("mysecret", s.Name, "should be equal")
+	s, err = secret.Get()
+	assert.Nil(err, "should be nil")
+	assert.Equal(secret.Value, s.Value, "should be equal")
+	_, err = secret.Recover()
+	assert.Error(err, "should be error")
+
+	// the secret is purged with its parts
+	_, _ = secret.Delete()
+	err = secret.Purge()
+	assert.Nil(err, "should be nil")
+	assert.Empty(mock.Store.Deleted, "should be empty")
+	err = secret.Purge()
+	assert.Error(err, "should be error")
+
+	_, err = secret.Delete()
+	assert.Error(err, "should be error")
+}
+
+func TestDeletedObject_Recoverable(t *testing.T) {
+	assert := assert.New(t)
+
+	// keyvaults without soft-delete dont return a recovery id
+	id := "https://mykeyvault.vault.azure.net/secrets/mysecret/123456"
+	d, err := newDeletedObject("secrets", &id, nil, nil, nil)
+	assert.Nil(err, "should be nil")
+	assert.Equal("mysecret", d.Name, "should be equal")
+	assert.False(d.Recoverable(), "should not be recoverable")
+	assert.Nil(d.ScheduledPurge, "should be nil")
+}
+
+func TestRestoreSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	source := MockKeyvault{Name: "source", Store: keyvaulttest.NewStore()}
+	secret := NewSecret(source, "mysecret", "")
+	secret.Tags = map[string]string{"owner": "team-a"}
+	secret.Encode("My little secret!")
+	_, _ = secret.Put()
+
+	f := filepath.Join(t.TempDir(), "MYSECRET.pem")
+	err := secret.Backup(f)
+	assert.Nil(err, "should be nil")
+
+	// the backup is restored into the target keyvault
+	target := MockKeyvault{Name: "target", Store: keyvaulttest.NewStore()}
+	s, err := RestoreSecret(target, f)
+	assert.Nil(err, "should be nil")
+	assert.Equal("mysecret", s.Name, "should be equal")
+	assert.Equal("target", s.Id.GetKeyvault(), "should be equal")
+	assert.Equal("team-a", s.Tags["owner"], "should be equal")
+	restored := NewSecret(target, "mysecret", "")
+	restored, _ = restored.Get()
+	dec, _ := restored.Decode()
+	assert.Equal("My little secret!", dec, "should be equal")
+
+	// existing secrets arent overwritten
+	_, err = RestoreSecret(source, f)
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), "already exists", "should contain the conflict")
+
+	_ = os.WriteFile(f, []byte("invalid"), 0644)
+	_, err = RestoreSecret(target, f)
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), "same subscription", "should contain the reason")
+
+	// backups of secrets split into multiple parts dont contain the parts
+	long := NewSecret(source, "long", "")
+	long.Encode(strings.Repeat("key: value\n", 5000))
+	_, _ = long.Put()
+	f = filepath.Join(t.TempDir(), "LONG.pem")
+	_ = long.Backup(f)
+	_, err = RestoreSecret(target, f)
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), "without its parts", "should contain the reason")
 }
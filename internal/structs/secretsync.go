@@ -0,0 +1,182 @@
+package structs
+
+import (
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault"
+	"regexp"
+	"strings"
+)
+
+const (
+	// actions required to bring the secrets of a keyvault in line with their source
+	SecretChangeCreate    = "create"
+	SecretChangeUpdate    = "update"
+	SecretChangeDelete    = "delete"
+	SecretChangeUnchanged = "unchanged"
+)
+
+// SecretChange - change of a single secret of the target keyvault
+type SecretChange struct {
+	Action string `json:"action"`
+	Name   string `json:"name"`
+	// name of the source secret if the secret is renamed, or the file the secret is read from
+	Source string `json:"source,omitempty"`
+
+	// secret to put for creates and updates, or to delete
+	secret Secret
+}
+
+// SecretRename - rename secrets by replacing the matches of the pattern
+type SecretRename struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// ParseSecretRename - parse a rename in the form "pattern=replacement", the replacement can reference
+// groups of the regular expression, e.g. "^staging-(.*)=prod-$1"
+func ParseSecretRename(rename string) (*SecretRename, error) {
+	if rename == "" {
+		return nil, nil
+	}
+	pr := strings.SplitN(rename, "=", 2)
+	if len(pr) != 2 || pr[0] == "" {
+		return nil, fmt.Errorf("Invalid rename '%s', expected pattern=replacement", rename)
+	}
+	p, err := regexp.Compile(pr[0])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid rename pattern '%s': %v", pr[0], err)
+	}
+	return &SecretRename{Pattern: p, Replacement: pr[1]}, nil
+}
+
+// Apply - return the renamed secret name
+func (r *SecretRename) Apply(name string) string {
+	if r == nil {
+		return name
+	}
+	return r.Pattern.ReplaceAllString(name, r.Replacement)
+}
+
+// SecretSyncOptions - selection and renaming of the secrets copied between keyvaults
+type SecretSyncOptions struct {
+	Filter SecretFilter
+	Rename *SecretRename
+	// delete the secrets of the target keyvault matching the filter which dont exist in the source
+	Prune bool
+}
+
+// PlanSecretSync - return the changes required to copy the secrets matching the filter from the source into
+// the target keyvault. Secrets keep their value, content type, tags and attributes, secrets with the same value
+// and attributes in the target keyvault are unchanged. Disabled secrets of the target keyvault are updated but
+// stay disabled, secrets managed by certificates are skipped. With prune a prefix or tag filter is required
+// to keep secrets not managed by the source
+func PlanSecretSync(from keyvault.KeyvaultInterface, to keyvault.KeyvaultInterface, opts SecretSyncOptions) ([]SecretChange, error) {
+
+	if opts.Prune && opts.Filter.Prefix == "" && len(opts.Filter.Tags) == 0 {
+		return nil, fmt.Errorf("Pruning requires a prefix or tag filter, otherwise every secret of the target keyvault not in the source is deleted")
+	}
+
+	sl := SecretList{}
+	selected, err := sl.selectSecrets(from, opts.Filter, true, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// disabled secrets of the source cant be copied, they are only kept from being pruned
+	var secrets []Secret
+	sources := map[string]string{}
+	for _, s := range selected {
+		name := opts.Rename.Apply(s.Name)
+		if !objectNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("Invalid secret name '%s', renamed from %s", name, s.Name)
+		}
+		if src, exists := sources[name]; exists {
+			return nil, fmt.Errorf("Secrets %s and %s are both renamed to %s", src, s.Name, name)
+		}
+		sources[name] = s.Name
+		if !s.disabled() {
+			secrets = append(secrets, s)
+		}
+	}
+	secrets, err = sl.GetAll(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	var copies []Secret
+	for _, s := range secrets {
+		name := opts.Rename.Apply(s.Name)
+
+		c := NewSecret(to, name, "")
+		c.Value = s.Value
+		c.ContentType = s.ContentType
+		c.Tags = s.Tags
+		c.Enabled = s.Enabled
+		c.NotBefore = s.NotBefore
+		c.Expires = s.Expires
+		copies = append(copies, c)
+	}
+
+	// the secrets matching the filter after renaming are replaced by the copies
+	target := opts.Filter
+	target.Prefix = opts.Rename.Apply(target.Prefix)
+	existing, err := sl.selectSecrets(to, target, true, false)
+	if err != nil {
+		return nil, err
+	}
+	vault := map[string]Secret{}
+	for _, s := range existing {
+		vault[s.Name] = s
+	}
+
+	var changes []SecretChange
+	for _, c := range copies {
+		change := SecretChange{Action: SecretChangeCreate, Name: c.Name, secret: c}
+		if sources[c.Name] != c.Name {
+			change.Source = sources[c.Name]
+		}
+		if v, exists := vault[c.Name]; exists {
+			change.Action = SecretChangeUpdate
+			if v.disabled() {
+				// disabled secrets cant be compared
+				change.secret.Enabled = v.Enabled
+			} else if c.Unchanged() {
+				change.Action = SecretChangeUnchanged
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	if opts.Prune {
+		for _, s := range existing {
+			if _, exists := sources[s.Name]; !exists {
+				changes = append(changes, SecretChange{Action: SecretChangeDelete, Name: s.Name, secret: s})
+			}
+		}
+	}
+	return changes, nil
+}
+
+// ApplySecretChanges - put the created and updated secrets and delete the pruned secrets. The secrets are
+// only deleted once all secrets are put, a failed put keeps every secret
+func ApplySecretChanges(changes []SecretChange) error {
+	for _, c := range changes {
+		if c.Action != SecretChangeCreate && c.Action != SecretChangeUpdate {
+			continue
+		}
+		_, err := c.secret.Put()
+		if err != nil {
+			return fmt.Errorf("Unable to %s secret %s: %v", c.Action, c.Name, err)
+		}
+	}
+	for _, c := range changes {
+		if c.Action != SecretChangeDelete {
+			continue
+		}
+		_, err := c.secret.Delete()
+		if err != nil {
+			return fmt.Errorf("Unable to %s secret %s: %v", c.Action, c.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package structs
+
+import (
+	"encoding/base64"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault/keyvaulttest"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSecretRename(t *testing.T) {
+	assert := assert.New(t)
+
+	r, err := ParseSecretRename("")
+	assert.Nil(err, "should be nil")
+	assert.Equal("staging-db", r.Apply("staging-db"), "should be unchanged")
+
+	r, err = ParseSecretRename("^staging-(.*)=prod-$1")
+	assert.Nil(err, "should be nil")
+	assert.Equal("prod-db", r.Apply("staging-db"), "should be renamed")
+	assert.Equal("other", r.Apply("other"), "should be unchanged")
+
+	_, err = ParseSecretRename("staging-")
+	assert.Error(err, "should be error")
+	_, err = ParseSecretRename("=prod-")
+	assert.Error(err, "should be error")
+	_, err = ParseSecretRename("(=prod-")
+	assert.Error(err, "should be error")
+}
+
+func TestPlanSecretSync(t *testing.T) {
+	assert := assert.New(t)
+
+	from := MockKeyvault{Name: "staging", Store: keyvaulttest.NewStore()}
+	to := MockKeyvault{Name: "prod", Store: keyvaulttest.NewStore()}
+
+	expires := JTime(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	for _, n := range []string{"app-a", "app-b", "app-c", "other"} {
+		s := NewSecret(from, n, "")
+		s.Encode("value of " + n)
+		s.Tags = map[string]string{"owner": "team-a"}
+		s.Expires = &expires
+		_, _ = s.Put()
+	}
+	plain := NewSecret(from, "app-plain", "")
+	plain.Value = "plain value"
+	plain.ContentType = ContentTypePlain
+	_, _ = plain.Put()
+	large := NewSecret(from, "app-large", "")
+	large.Encode(strings.Repeat("x", 3*secretChunkSize))
+	_, _ = large.Put()
+
+	opts := SecretSyncOptions{Filter: SecretFilter{Prefix: "app-"}}
+	changes, err := PlanSecretSync(from, to, opts)
+	assert.Nil(err, "should be nil")
+	assert.Len(changes, 5, "should contain the secrets matching the prefix")
+	for _, c := range changes {
+		assert.Equal(SecretChangeCreate, c.Action, "should be created")
+	}
+	err = ApplySecretChanges(changes)
+	assert.Nil(err, "should be nil")
+
+	// content type, tags and attributes are preserved
+	copied := NewSecret(to, "app-plain", "")
+	copied, _ = copied.Get()
+	assert.Equal(ContentTypePlain, copied.ContentType, "should be equal")
+	assert.Equal("plain value", copied.Value, "should be equal")
+	copied = NewSecret(to, "app-a", "")
+	copied, _ = copied.Get()
+	assert.Equal("team-a", copied.Tags["owner"], "should be equal")
+	assert.Equal(time.Time(expires).Unix(), time.Time(*copied.Expires).Unix(), "should be equal")
+	copied = NewSecret(to, "app-large", "")
+	copied, _ = copied.Get()
+	dec, _ := copied.Decode()
+	assert.Equal(3*secretChunkSize, len(dec), "should be reassembled")
+
+	// only changed secrets are written
+	s := NewSecret(from, "app-b", "")
+	s.Encode("new value")
+	_, _ = s.Put()
+	s = NewSecret(to, "app-extra", "")
+	s.Encode("extra")
+	_, _ = s.Put()
+	changes, err = PlanSecretSync(from, to, opts)
+	assert.Nil(err, "should be nil")
+	actions := map[string]string{}
+	for _, c := range changes {
+		actions[c.Name] = c.Action
+	}
+	assert.Equal(map[string]string{
+		"app-a": SecretChangeUnchanged, "app-b": SecretChangeUpdate, "app-c": SecretChangeUnchanged,
+		"app-plain": SecretChangeUnchanged, "app-large": SecretChangeUnchanged,
+	}, actions, "should be equal")
+	err = ApplySecretChanges(changes)
+	assert.Nil(err, "should be nil")
+	assert.Len(to.Store.Secrets["app-a"], 1, "should not be written")
+	assert.Len(to.Store.Secrets["app-b"], 2, "should be updated")
+
+	// secrets missing in the source are pruned
+	opts.Prune = true
+	changes, err = PlanSecretSync(from, to, opts)
+	assert.Nil(err, "should be nil")
+	pruned := changes[len(changes)-1]
+	assert.Equal(SecretChangeDelete, pruned.Action, "should be equal")
+	assert.Equal("app-extra", pruned.Name, "should be equal")
+	err = ApplySecretChanges(changes)
+	assert.Nil(err, "should be nil")
+	assert.Len(to.Store.Secrets["app-extra"], 0, "should be deleted")
+	assert.Len(to.Store.Deleted["app-extra"], 1, "should be deleted")
+}
+
+func TestPlanSecretSync_Rename(t *testing.T) {
+	assert := assert.New(t)
+
+	from := MockKeyvault{Name: "staging", Store: keyvaulttest.NewStore()}
+	to := MockKeyvault{Name: "prod", Store: keyvaulttest.NewStore()}
+	for _, n := range []string{"staging-a", "staging-b"} {
+		s := NewSecret(from, n, "")
+		s.Encode("value of " + n)
+		_, _ = s.Put()
+	}
+	s := NewSecret(to, "prod-obsolete", "")
+	s.Encode("obsolete")
+	_, _ = s.Put()
+	s = NewSecret(to, "unrelated", "")
+	s.Encode("unrelated")
+	_, _ = s.Put()
+
+	rename, _ := ParseSecretRename("^staging-=prod-")
+	opts := SecretSyncOptions{Filter: SecretFilter{Prefix: "staging-"}, Rename: rename, Prune: true}
+	changes, err := PlanSecretSync(from, to, opts)
+	assert.Nil(err, "should be nil")
+	assert.Len(changes, 3, "should be equal")
+	assert.Equal("prod-a", changes[0].Name, "should be renamed")
+	assert.Equal("staging-a", changes[0].Source, "should contain the source")
+	// the prefix is renamed as well, secrets outside of the prefix arent pruned
+	assert.Equal(SecretChangeDelete, changes[2].Action, "should be equal")
+	assert.Equal("prod-obsolete", changes[2].Name, "should be equal")
+
+	rename, _ = ParseSecretRename("^staging-.*=prod")
+	opts.Rename = rename
+	_, err = PlanSecretSync(from, to, opts)
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), "both renamed", "should contain the conflict")
+
+	rename, _ = ParseSecretRename("^staging-=prod_")
+	opts.Rename = rename
+	_, err = PlanSecretSync(from, to, opts)
+	assert.Error(err, "should be error")
+}
+
+func TestPlanSecretSync_DisabledAndManaged(t *testing.T) {
+	assert := assert.New(t)
+
+	from := MockKeyvault{Name: "staging", Store: keyvaulttest.NewStore()}
+	to := MockKeyvault{Name: "prod", Store: keyvaulttest.NewStore()}
+	disabled, managed := false, true
+	put := func(kv MockKeyvault, name string, value string, enabled *bool) {
+		s := NewSecret(kv, name, "")
+		s.Encode(value)
+		s.Enabled = enabled
+		_, _ = s.Put()
+	}
+	for _, n := range []string{"app-a", "app-cert", "app-large"} {
+		put(from, n, "value of "+n, nil)
+	}
+	put(from, "app-disabled", "disabled", &disabled)
+	from.Store.Secrets["app-cert"][0].Managed = &managed
+	put(to, "app-a", "old value", &disabled)
+	put(to, "app-disabled", "old value", nil)
+	put(to, "app-managed", "certificate", nil)
+	to.Store.Secrets["app-managed"][0].Managed = &managed
+	put(to, "app-stale", "stale", nil)
+
+	_, err := PlanSecretSync(from, to, SecretSyncOptions{Prune: true})
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), "requires a prefix or tag filter", "should contain the reason")
+
+	// managed secrets are neither copied nor pruned, disabled secrets of the source arent pruned
+	opts := SecretSyncOptions{Filter: SecretFilter{Prefix: "app-"}, Prune: true}
+	changes, err := PlanSecretSync(from, to, opts)
+	assert.Nil(err, "should be nil")
+	actions := map[string]string{}
+	for _, c := range changes {
+		actions[c.Name] = c.Action
+	}
+	assert.Equal(map[string]string{
+		"app-a": SecretChangeUpdate, "app-large": SecretChangeCreate, "app-stale": SecretChangeDelete,
+	}, actions, "should be equal")
+
+	// disabled secrets of the target stay disabled
+	err = ApplySecretChanges(changes)
+	assert.Nil(err, "should be nil")
+	sb, _ := to.GetSecret("app-a", "")
+	assert.False(*sb.Attributes.Enabled, "should be disabled")
+	assert.Equal(base64.StdEncoding.EncodeToString([]byte("value of app-a")), *sb.Value, "should be updated")
+	assert.Len(to.Store.Secrets["app-managed"], 1, "should be kept")
+
+	// secrets are only pruned once all secrets are put
+	put(to, "app-stale", "stale", nil)
+	large := NewSecret(from, "app-large", "")
+	large.Encode(strings.Repeat("x", 2*secretChunkSize))
+	_, _ = large.Put()
+	put(to, "app-large-part-1", "foreign", nil)
+	changes, err = PlanSecretSync(from, to, opts)
+	assert.Nil(err, "should be nil")
+	err = ApplySecretChanges(changes)
+	assert.Error(err, "should be error")
+	assert.Len(to.Store.Secrets["app-stale"], 1, "should be kept")
+}
@@ -0,0 +1,67 @@
+package structs
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+// Values - helm values document
+type Values map[string]interface{}
+
+// splitValuesPath - split a dotted path (db.password) into its keys
+func splitValuesPath(path string) ([]string, error) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys {
+		if k == "" {
+			return nil, fmt.Errorf("Invalid values path '%s'", path)
+		}
+	}
+	return keys, nil
+}
+
+// Set - set the value at the given dotted path, intermediate maps are created as required
+func (v Values) Set(path string, value interface{}) error {
+	keys, err := splitValuesPath(path)
+	if err != nil {
+		return err
+	}
+
+	m := v
+	for i, k := range keys[:len(keys)-1] {
+		next, exists := m[k]
+		if !exists {
+			next = Values{}
+			m[k] = next
+		}
+		nm, ok := next.(Values)
+		if !ok {
+			return fmt.Errorf("Values path '%s' conflicts with existing value at '%s'", path, strings.Join(keys[:i+1], "."))
+		}
+		m = nm
+	}
+
+	last := keys[len(keys)-1]
+	if _, exists := m[last]; exists {
+		return fmt.Errorf("Values path '%s' is already set", path)
+	}
+	m[last] = value
+	return nil
+}
+
+// Yaml - return the values as yaml document, keys are sorted to ensure a stable output
+func (v Values) Yaml() (string, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err := enc.Encode(map[string]interface{}(v))
+	if err != nil {
+		return "", err
+	}
+	err = enc.Close()
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,54 @@
+package structs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func TestValues_Set(t *testing.T) {
+	assert := assert.New(t)
+
+	values := Values{}
+	assert.Nil(values.Set("db.password", "secret"), "should be nil")
+	assert.Nil(values.Set("db.user", "admin"), "should be nil")
+	assert.Nil(values.Set("token", "abc"), "should be nil")
+
+	assert.Equal(Values{"db": Values{"password": "secret", "user": "admin"}, "token": "abc"}, values)
+
+	// invalid paths and conflicting values
+	assert.Error(values.Set("", "value"), "should be error")
+	assert.Error(values.Set("db..password", "value"), "should be error")
+	assert.Error(values.Set("db.", "value"), "should be error")
+	assert.Error(values.Set("token.value", "value"), "should be error")
+	assert.Error(values.Set("db", "value"), "should be error")
+	assert.Error(values.Set("db.password", "value"), "should be error")
+}
+
+func TestValues_Yaml(t *testing.T) {
+	assert := assert.New(t)
+
+	values := Values{}
+	_ = values.Set("db.password", "multi\nline\n")
+	_ = values.Set("db.user", "yes")
+	_ = values.Set("a.quote", "it's: \"quoted\"")
+
+	y, err := values.Yaml()
+	assert.Nil(err, "should be nil")
+	assert.Equal(`a:
+  quote: 'it''s: "quoted"'
+db:
+  password: |
+    multi
+    line
+  user: "yes"
+`, y)
+
+	// the rendered values parse back into the original strings
+	var parsed map[string]map[string]string
+	err = yaml.Unmarshal([]byte(y), &parsed)
+	assert.Nil(err, "should be nil")
+	assert.Equal("multi\nline\n", parsed["db"]["password"])
+	assert.Equal("yes", parsed["db"]["user"])
+	assert.Equal("it's: \"quoted\"", parsed["a"]["quote"])
+}
@@ -0,0 +1,448 @@
+package structs
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// name of the manifest inside the backup archive
+	vaultBackupManifest = "manifest.json"
+
+	// status of the objects restored from a backup archive
+	RestoreStatusRestored    = "restored"
+	RestoreStatusOverwritten = "overwritten"
+	RestoreStatusSkipped     = "skipped"
+	RestoreStatusFailed      = "failed"
+	RestoreStatusAborted     = "aborted"
+)
+
+var (
+	// object types contained in backup archives
+	vaultBackupTypes = []string{"secrets", "keys"}
+
+	// deleting and purging keyvault objects is asynchronous, conflicting requests are retried
+	vaultRestoreRetries = 30
+	vaultRestoreDelay   = 2 * time.Second
+)
+
+// VaultBackup - manifest of a backup archive containing the backups of secrets and keys
+type VaultBackup struct {
+	Keyvault string              `json:"keyvault"`
+	Created  JTime               `json:"created"`
+	Objects  []VaultBackupObject `json:"objects"`
+}
+
+// VaultBackupObject - backup of a single secret or key inside the backup archive
+type VaultBackupObject struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	File string `json:"file"`
+}
+
+// VaultRestoreResult - result of restoring a single object of a backup archive
+type VaultRestoreResult struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// vaultObjectOps - keyvault operations required to backup and restore an object type
+type vaultObjectOps struct {
+	backup  func(name string) (string, error)
+	restore func(file string) error
+	// delete returns false if the keyvault doesnt have soft-delete enabled and the object is gone
+	delete func(name string) (bool, error)
+	purge  func(name string) error
+}
+
+// vaultOps - return the keyvault operations of the given object type
+func vaultOps(kv keyvault.KeyvaultInterface, objectType string) vaultObjectOps {
+	if objectType == "keys" {
+		return vaultObjectOps{
+			backup: kv.BackupKey,
+			restore: func(file string) error {
+				_, err := kv.RestoreKey(file)
+				return err
+			},
+			delete: func(name string) (bool, error) {
+				d, err := kv.DeleteKey(name)
+				return d.RecoveryID != nil, err
+			},
+			purge: kv.PurgeDeletedKey,
+		}
+	}
+	return vaultObjectOps{
+		backup: kv.BackupSecret,
+		restore: func(file string) error {
+			_, err := kv.RestoreSecret(file)
+			return err
+		},
+		delete: func(name string) (bool, error) {
+			d, err := kv.DeleteSecret(name)
+			return d.RecoveryID != nil, err
+		},
+		purge: kv.PurgeDeletedSecret,
+	}
+}
+
+// ValidateBackupTypes - return an error if the given object types cant be backed up
+func ValidateBackupTypes(types []string) error {
+	for _, t := range types {
+		if !contains(vaultBackupTypes, t) {
+			return fmt.Errorf("Unsupported object type '%s', use '%s'", t, strings.Join(vaultBackupTypes, "' or '"))
+		}
+	}
+	return nil
+}
+
+// archiveCompression - return the compression of the archive derived from its file extension
+func archiveCompression(archive string) (string, error) {
+	switch {
+	case strings.HasSuffix(archive, ".tar.zst"):
+		return CompressionZstd, nil
+	case strings.HasSuffix(archive, ".tar.gz"), strings.HasSuffix(archive, ".tgz"):
+		return CompressionGzip, nil
+	case strings.HasSuffix(archive, ".tar"):
+		return CompressionNone, nil
+	}
+	return "", fmt.Errorf("Unsupported archive '%s', use .tar.zst, .tar.gz or .tar", archive)
+}
+
+// backupObjects - return the secrets and keys of the given types matching the filter. Secrets containing parts
+// of a selected secret are selected as well, they are listed before the secret they are part of.
+// Secrets and keys managed by certificates are skipped, they can only be backed up with their certificate
+func backupObjects(kv keyvault.KeyvaultInterface, filter SecretFilter, types []string) ([]VaultBackupObject, error) {
+	var objects []VaultBackupObject
+
+	if contains(types, "secrets") {
+		sb, err := kv.ListSecrets()
+		if err != nil {
+			return nil, err
+		}
+
+		selected := map[string]bool{}
+		for _, s := range sb {
+			if isManaged(s.Managed) {
+				continue
+			}
+			sid := KeyvaultObjectId(*s.ID)
+			tags := convertTags(s.Tags)
+			if _, part := tags[TagPartOf]; !part && filter.Match(sid.GetName(), tags) {
+				selected[sid.GetName()] = true
+			}
+		}
+		var parts, secrets []VaultBackupObject
+		for _, s := range sb {
+			if isManaged(s.Managed) {
+				continue
+			}
+			sid := KeyvaultObjectId(*s.ID)
+			o := VaultBackupObject{Type: "secrets", Name: sid.GetName(), File: fmt.Sprintf("secrets/%s.pem", sid.GetName())}
+			if s.Tags[TagPartOf] != nil && selected[*s.Tags[TagPartOf]] {
+				parts = append(parts, o)
+			} else if selected[o.Name] {
+				secrets = append(secrets, o)
+			}
+		}
+		objects = append(objects, parts...)
+		objects = append(objects, secrets...)
+	}
+
+	if contains(types, "keys") {
+		kb, err := kv.ListKeys()
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range kb {
+			kid := KeyvaultObjectId(*k.Key.Kid)
+			if isManaged(k.Managed) || !filter.Match(kid.GetName(), convertTags(k.Tags)) {
+				continue
+			}
+			objects = append(objects, VaultBackupObject{Type: "keys", Name: kid.GetName(), File: fmt.Sprintf("keys/%s.pem", kid.GetName())})
+		}
+	}
+
+	return objects, nil
+}
+
+// BackupVault - write the backups of all secrets and keys of the given types matching the filter into
+// the archive. The archive contains a manifest listing the objects and a backup file per object
+func BackupVault(kv keyvault.KeyvaultInterface, filter SecretFilter, types []string, archive string) (VaultBackup, error) {
+	compression, err := archiveCompression(archive)
+	if err != nil {
+		return VaultBackup{}, err
+	}
+	err = ValidateBackupTypes(types)
+	if err != nil {
+		return VaultBackup{}, err
+	}
+
+	vb := VaultBackup{Keyvault: kv.GetKeyvaultName(), Created: JTime(time.Now())}
+	vb.Objects, err = backupObjects(kv, filter, types)
+	if err != nil {
+		return VaultBackup{}, err
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	add := func(name string, content []byte) error {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), ModTime: time.Time(vb.Created)})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(content)
+		return err
+	}
+
+	for _, o := range vb.Objects {
+		backup, err := vaultOps(kv, o.Type).backup(o.Name)
+		if err != nil {
+			return VaultBackup{}, fmt.Errorf("Unable to backup %s %s: %v", strings.TrimSuffix(o.Type, "s"), o.Name, err)
+		}
+		err = add(o.File, []byte(backup))
+		if err != nil {
+			return VaultBackup{}, err
+		}
+	}
+
+	manifest, err := json.MarshalIndent(vb, "", "  ")
+	if err != nil {
+		return VaultBackup{}, err
+	}
+	err = add(vaultBackupManifest, manifest)
+	if err != nil {
+		return VaultBackup{}, err
+	}
+	err = tw.Close()
+	if err != nil {
+		return VaultBackup{}, err
+	}
+
+	compressed, err := compress(compression, buf.Bytes())
+	if err != nil {
+		return VaultBackup{}, err
+	}
+	return vb, os.WriteFile(archive, compressed, 0600)
+}
+
+// readVaultBackup - read the manifest and the backup files of the archive
+func readVaultBackup(archive string) (VaultBackup, map[string][]byte, error) {
+	compression, err := archiveCompression(archive)
+	if err != nil {
+		return VaultBackup{}, nil, err
+	}
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		return VaultBackup{}, nil, err
+	}
+	data, err = decompress(compression, data)
+	if err != nil {
+		return VaultBackup{}, nil, fmt.Errorf("Unable to decompress %s: %v", archive, err)
+	}
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return VaultBackup{}, nil, fmt.Errorf("Invalid backup archive %s: %v", archive, err)
+		}
+		files[path.Clean(h.Name)], err = io.ReadAll(tr)
+		if err != nil {
+			return VaultBackup{}, nil, err
+		}
+	}
+
+	var vb VaultBackup
+	manifest, exists := files[vaultBackupManifest]
+	if !exists {
+		return VaultBackup{}, nil, fmt.Errorf("Invalid backup archive %s: %s not found", archive, vaultBackupManifest)
+	}
+	err = json.Unmarshal(manifest, &vb)
+	if err != nil {
+		return VaultBackup{}, nil, fmt.Errorf("Invalid backup archive %s: %v", archive, err)
+	}
+	for _, o := range vb.Objects {
+		if !contains(vaultBackupTypes, o.Type) {
+			return VaultBackup{}, nil, fmt.Errorf("Invalid backup archive %s: unsupported object type '%s'", archive, o.Type)
+		}
+		if !objectNameRegexp.MatchString(o.Name) {
+			return VaultBackup{}, nil, fmt.Errorf("Invalid backup archive %s: invalid object name '%s'", archive, o.Name)
+		}
+		if path.Dir(path.Clean(o.File)) != o.Type {
+			return VaultBackup{}, nil, fmt.Errorf("Invalid backup archive %s: %s isn't inside %s/", archive, o.File, o.Type)
+		}
+		if _, exists := files[path.Clean(o.File)]; !exists {
+			return VaultBackup{}, nil, fmt.Errorf("Invalid backup archive %s: %s not found", archive, o.File)
+		}
+	}
+	return vb, files, nil
+}
+
+// existingObjects - return the existing and the deleted (not purged) objects of the keyvault as <type>/<name>.
+// Objects managed by certificates are skipped, they cant be deleted and restoring over them fails with a conflict
+func existingObjects(kv keyvault.KeyvaultInterface, types []string) (map[string]bool, map[string]bool, error) {
+	existing := map[string]bool{}
+	deleted := map[string]bool{}
+
+	if contains(types, "secrets") {
+		sb, err := kv.ListSecrets()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, s := range sb {
+			if isManaged(s.Managed) {
+				continue
+			}
+			sid := KeyvaultObjectId(*s.ID)
+			existing["secrets/"+sid.GetName()] = true
+		}
+		// keyvaults without soft-delete cant list deleted secrets
+		ds, _ := kv.ListDeletedSecrets()
+		for _, s := range ds {
+			if isManaged(s.Managed) {
+				continue
+			}
+			sid := KeyvaultObjectId(*s.ID)
+			deleted["secrets/"+sid.GetName()] = true
+		}
+	}
+
+	if contains(types, "keys") {
+		kb, err := kv.ListKeys()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, k := range kb {
+			if isManaged(k.Managed) {
+				continue
+			}
+			kid := KeyvaultObjectId(*k.Key.Kid)
+			existing["keys/"+kid.GetName()] = true
+		}
+		dk, _ := kv.ListDeletedKeys()
+		for _, k := range dk {
+			if isManaged(k.Managed) {
+				continue
+			}
+			kid := KeyvaultObjectId(*k.Key.Kid)
+			deleted["keys/"+kid.GetName()] = true
+		}
+	}
+
+	return existing, deleted, nil
+}
+
+// retryConflict - retry the given keyvault operation as long as it fails with a conflict
+func retryConflict(f func() error) error {
+	err := f()
+	for i := 1; i < vaultRestoreRetries && isConflict(err); i++ {
+		time.Sleep(vaultRestoreDelay)
+		err = f()
+	}
+	return err
+}
+
+// RestoreVault - restore the secrets and keys of the backup archive into the keyvault. Existing and deleted
+// objects are skipped, with overwrite they are deleted and purged before the backup is restored.
+// The result of every object is returned. A failed restore aborts the remaining objects unless the object
+// merely conflicted with an existing one, otherwise every further overwrite could purge another object
+func RestoreVault(kv keyvault.KeyvaultInterface, archive string, overwrite bool) ([]VaultRestoreResult, error) {
+	vb, files, err := readVaultBackup(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	var types []string
+	for _, o := range vb.Objects {
+		if !contains(types, o.Type) {
+			types = append(types, o.Type)
+		}
+	}
+	existing, deleted, err := existingObjects(kv, types)
+	if err != nil {
+		return nil, err
+	}
+
+	// the keyvault client restores backups from files
+	tmp, err := os.MkdirTemp("", "helm-keyvault-restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	var results []VaultRestoreResult
+	aborted := false
+	for _, o := range vb.Objects {
+		r := VaultRestoreResult{Type: o.Type, Name: o.Name}
+		if aborted {
+			r.Status = RestoreStatusAborted
+			results = append(results, r)
+			continue
+		}
+		var abort bool
+		r.Status, abort, err = restoreObject(kv, o, files[path.Clean(o.File)], tmp, existing[o.Type+"/"+o.Name], deleted[o.Type+"/"+o.Name], overwrite)
+		if err != nil {
+			r.Status = RestoreStatusFailed
+			r.Error = err.Error()
+			aborted = abort
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// restoreObject - restore the backup of a single object and return the status. abort is set if the restore
+// failed after the existing object was purged or for a reason other than a conflict
+func restoreObject(kv keyvault.KeyvaultInterface, o VaultBackupObject, backup []byte, tmp string, exists bool, deleted bool, overwrite bool) (status string, abort bool, err error) {
+	if (exists || deleted) && !overwrite {
+		return RestoreStatusSkipped, false, nil
+	}
+	ops := vaultOps(kv, o.Type)
+
+	purge := deleted
+	if exists {
+		recoverable, err := ops.delete(o.Name)
+		if err != nil {
+			return "", false, fmt.Errorf("Unable to delete the existing object: %v", err)
+		}
+		purge = recoverable
+	}
+	if purge {
+		err := retryConflict(func() error { return ops.purge(o.Name) })
+		if err != nil {
+			return "", true, fmt.Errorf("Unable to purge the deleted object: %v", err)
+		}
+	}
+
+	f := filepath.Join(tmp, fmt.Sprintf("%s-%s.pem", o.Type, o.Name))
+	err = os.WriteFile(f, backup, 0600)
+	if err != nil {
+		return "", true, err
+	}
+	defer os.Remove(f)
+	err = retryConflict(func() error { return ops.restore(f) })
+	if err != nil {
+		return "", exists || deleted || !isConflict(err), restoreError(strings.TrimSuffix(o.Type, "s"), kv.GetKeyvaultName(), err)
+	}
+
+	if exists || deleted {
+		return RestoreStatusOverwritten, false, nil
+	}
+	return RestoreStatusRestored, false, nil
+}
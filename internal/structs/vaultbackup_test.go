@@ -0,0 +1,235 @@
+package structs
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/foryouandyourcustomers/helm-keyvault/internal/keyvault/keyvaulttest"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupVault(t *testing.T) {
+	assert := assert.New(t)
+
+	vaultRestoreDelay = 0
+	defer func() { vaultRestoreDelay = 2 * time.Second }()
+
+	source := MockKeyvault{Name: "source", Store: keyvaulttest.NewStore()}
+	for _, n := range []string{"app-a", "app-b", "other"} {
+		s := NewSecret(source, n, "")
+		s.Encode("value of " + n)
+		_, _ = s.Put()
+	}
+	// parts of selected secrets are part of the backup
+	value, partOf := "part", "app-a"
+	source.Store.Set("source", "app-a-part-1", keyvault.SecretSetParameters{Value: &value, Tags: map[string]*string{TagPartOf: &partOf}})
+	// secrets managed by certificates arent part of the backup
+	managed := true
+	source.Store.Set("source", "app-cert", keyvault.SecretSetParameters{Value: &value})
+	source.Store.Secrets["app-cert"][0].Managed = &managed
+
+	archive := filepath.Join(t.TempDir(), "vault.tar.zst")
+	vb, err := BackupVault(source, SecretFilter{Prefix: "app-"}, []string{"secrets", "keys"}, archive)
+	assert.Nil(err, "should be nil")
+	assert.Equal("source", vb.Keyvault, "should be equal")
+	assert.Equal([]VaultBackupObject{
+		{Type: "secrets", Name: "app-a-part-1", File: "secrets/app-a-part-1.pem"},
+		{Type: "secrets", Name: "app-a", File: "secrets/app-a.pem"},
+		{Type: "secrets", Name: "app-b", File: "secrets/app-b.pem"},
+	}, vb.Objects, "should be equal")
+
+	vb, err = BackupVault(source, SecretFilter{}, []string{"keys"}, archive)
+	assert.Nil(err, "should be nil")
+	assert.Len(vb.Objects, 5, "should contain all keys")
+	assert.Equal("keys/key-0.pem", vb.Objects[0].File, "should be equal")
+
+	_, err = BackupVault(source, SecretFilter{}, []string{"certificates"}, archive)
+	assert.Error(err, "should be error")
+	_, err = BackupVault(source, SecretFilter{}, []string{"secrets"}, filepath.Join(t.TempDir(), "vault.zip"))
+	assert.Error(err, "should be error")
+}
+
+func TestRestoreVault(t *testing.T) {
+	assert := assert.New(t)
+
+	vaultRestoreDelay = 0
+	defer func() { vaultRestoreDelay = 2 * time.Second }()
+
+	source := MockKeyvault{Name: "source", Store: keyvaulttest.NewStore()}
+	for _, n := range []string{"app-a", "app-b"} {
+		s := NewSecret(source, n, "")
+		s.Encode("value of " + n)
+		_, _ = s.Put()
+	}
+
+	for _, archive := range []string{"vault.tar.zst", "vault.tar.gz", "vault.tar"} {
+		archive = filepath.Join(t.TempDir(), archive)
+		_, err := BackupVault(source, SecretFilter{}, []string{"secrets", "keys"}, archive)
+		assert.Nil(err, "should be nil")
+
+		target := MockKeyvault{Name: "target", Store: keyvaulttest.NewStore()}
+		results, err := RestoreVault(target, archive, false)
+		assert.Nil(err, "should be nil")
+		assert.Len(results, 7, "should contain a result per object")
+		assert.Equal(VaultRestoreResult{Type: "secrets", Name: "app-a", Status: RestoreStatusRestored}, results[0], "should be equal")
+		// the keys of the mock keyvault already exist
+		assert.Equal(VaultRestoreResult{Type: "keys", Name: "key-0", Status: RestoreStatusSkipped}, results[2], "should be equal")
+
+		restored := NewSecret(target, "app-b", "")
+		restored, _ = restored.Get()
+		dec, _ := restored.Decode()
+		assert.Equal("value of app-b", dec, "should be equal")
+	}
+}
+
+func TestRestoreVault_Overwrite(t *testing.T) {
+	assert := assert.New(t)
+
+	vaultRestoreDelay = 0
+	defer func() { vaultRestoreDelay = 2 * time.Second }()
+
+	source := MockKeyvault{Name: "source", Store: keyvaulttest.NewStore()}
+	s := NewSecret(source, "app", "")
+	s.Encode("old")
+	_, _ = s.Put()
+	archive := filepath.Join(t.TempDir(), "vault.tgz")
+	_, err := BackupVault(source, SecretFilter{}, []string{"secrets", "keys"}, archive)
+	assert.Nil(err, "should be nil")
+
+	s.Encode("new")
+	_, _ = s.Put()
+
+	// existing secrets are skipped
+	results, err := RestoreVault(source, archive, false)
+	assert.Nil(err, "should be nil")
+	assert.Equal(RestoreStatusSkipped, results[0].Status, "should be equal")
+
+	results, err = RestoreVault(source, archive, true)
+	assert.Nil(err, "should be nil")
+	assert.Equal(VaultRestoreResult{Type: "secrets", Name: "app", Status: RestoreStatusOverwritten}, results[0], "should be equal")
+	restored := NewSecret(source, "app", "")
+	restored, _ = restored.Get()
+	dec, _ := restored.Decode()
+	assert.Equal("old", dec, "should be equal")
+
+	// the keys of the mock keyvault cant be replaced, the failed overwrite aborts the remaining objects
+	assert.Len(results, 6, "should contain a result per object")
+	assert.Equal(RestoreStatusFailed, results[1].Status, "should be equal")
+	assert.Contains(results[1].Error, "already exists", "should contain the reason")
+	for _, r := range results[2:] {
+		assert.Equal(RestoreStatusAborted, r.Status, "should be aborted")
+	}
+}
+
+func TestRestoreVault_Managed(t *testing.T) {
+	assert := assert.New(t)
+
+	vaultRestoreDelay = 0
+	defer func() { vaultRestoreDelay = 2 * time.Second }()
+
+	source := MockKeyvault{Name: "source", Store: keyvaulttest.NewStore()}
+	s := NewSecret(source, "app", "")
+	s.Encode("value")
+	_, _ = s.Put()
+	archive := filepath.Join(t.TempDir(), "vault.tar")
+	_, err := BackupVault(source, SecretFilter{}, []string{"secrets"}, archive)
+	assert.Nil(err, "should be nil")
+
+	// secrets managed by certificates arent deleted by an overwrite, the restore conflicts
+	target := MockKeyvault{Name: "target", Store: keyvaulttest.NewStore()}
+	value, managed := "certificate", true
+	target.Store.Set("target", "app", keyvault.SecretSetParameters{Value: &value})
+	target.Store.Secrets["app"][0].Managed = &managed
+	results, err := RestoreVault(target, archive, true)
+	assert.Nil(err, "should be nil")
+	assert.Len(results, 1, "should contain a result per object")
+	assert.Equal(RestoreStatusFailed, results[0].Status, "should be equal")
+	assert.Contains(results[0].Error, "already exists", "should contain the reason")
+	sb, _ := target.GetSecret("app", "")
+	assert.Equal("certificate", *sb.Value, "should be equal")
+}
+
+func TestRestoreVault_OverwriteFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	vaultRestoreDelay = 0
+	defer func() { vaultRestoreDelay = 2 * time.Second }()
+
+	mock := MockKeyvault{Name: "mykeyvault", Store: keyvaulttest.NewStore()}
+	for _, n := range []string{"app-a", "app-b"} {
+		s := NewSecret(mock, n, "")
+		s.Encode("value of " + n)
+		_, _ = s.Put()
+	}
+	backup, _ := mock.Store.Backup("app-b")
+
+	// the backup of the first secret is rejected after the existing secret was purged
+	archive := filepath.Join(t.TempDir(), "vault.tar")
+	writeVaultArchive(archive, `{"keyvault":"source","objects":[`+
+		`{"type":"secrets","name":"app-a","file":"secrets/app-a.pem"},`+
+		`{"type":"secrets","name":"app-b","file":"secrets/app-b.pem"}]}`,
+		map[string][]byte{"secrets/app-a.pem": []byte("invalid"), "secrets/app-b.pem": []byte(backup)})
+
+	results, err := RestoreVault(mock, archive, true)
+	assert.Nil(err, "should be nil")
+	assert.Equal(RestoreStatusFailed, results[0].Status, "should be equal")
+	assert.Equal(VaultRestoreResult{Type: "secrets", Name: "app-b", Status: RestoreStatusAborted}, results[1], "should be equal")
+
+	// nothing else is purged
+	assert.Len(mock.Store.Secrets["app-b"], 1, "should still exist")
+	assert.Len(mock.Store.Deleted["app-b"], 0, "should not be deleted")
+}
+
+func TestRestoreVault_InvalidArchive(t *testing.T) {
+	assert := assert.New(t)
+
+	mock := MockKeyvault{Name: "mykeyvault", Store: keyvaulttest.NewStore()}
+	dir := t.TempDir()
+
+	_, err := RestoreVault(mock, filepath.Join(dir, "missing.tar"), false)
+	assert.Error(err, "should be error")
+
+	archive := filepath.Join(dir, "vault.tar.zst")
+	_ = os.WriteFile(archive, []byte("invalid"), 0644)
+	_, err = RestoreVault(mock, archive, false)
+	assert.Error(err, "should be error")
+
+	// the manifest references a missing backup file
+	archive = filepath.Join(dir, "vault.tar")
+	writeVaultArchive(archive, `{"keyvault":"source","objects":[{"type":"secrets","name":"app","file":"secrets/app.pem"}]}`, nil)
+	_, err = RestoreVault(mock, archive, false)
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), "secrets/app.pem not found", "should contain the missing file")
+
+	// object names and files are validated
+	writeVaultArchive(archive, `{"keyvault":"source","objects":[{"type":"secrets","name":"../app","file":"secrets/app.pem"}]}`,
+		map[string][]byte{"secrets/app.pem": []byte("backup")})
+	_, err = RestoreVault(mock, archive, false)
+	assert.Error(err, "should be error")
+	assert.Contains(err.Error(), "invalid object name", "should contain the reason")
+	for _, f := range []string{"keys/app.pem", "app.pem", "secrets/../app.pem", "secrets/nested/app.pem"} {
+		writeVaultArchive(archive, `{"keyvault":"source","objects":[{"type":"secrets","name":"app","file":"`+f+`"}]}`,
+			map[string][]byte{f: []byte("backup")})
+		_, err = RestoreVault(mock, archive, false)
+		assert.Error(err, "should be error")
+		assert.Contains(err.Error(), "isn't inside secrets/", "should contain the reason")
+	}
+}
+
+// writeVaultArchive - write an uncompressed backup archive with the manifest and the backup files
+func writeVaultArchive(archive string, manifest string, files map[string][]byte) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	_ = tw.WriteHeader(&tar.Header{Name: vaultBackupManifest, Mode: 0600, Size: int64(len(manifest))})
+	_, _ = tw.Write([]byte(manifest))
+	for n, b := range files {
+		_ = tw.WriteHeader(&tar.Header{Name: n, Mode: 0600, Size: int64(len(b))})
+		_, _ = tw.Write(b)
+	}
+	_ = tw.Close()
+	_ = os.WriteFile(archive, buf.Bytes(), 0644)
+}